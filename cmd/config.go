@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(b, &ns); err != nil {
+			return fmt.Errorf("duration must be a string like \"1s\" or nanoseconds, got %s", b)
+		}
+		*d = Duration(ns)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type RouteConfig struct {
+	Name      string              `json:"name"`
+	Path      string              `json:"path"`
+	Algorithm ratelimit.Algorithm `json:"algorithm"`
+	Limit     int                 `json:"limit"`
+	Rate      Duration            `json:"rate"`
+	PerSecond float64             `json:"per_second"`
+	Window    Duration            `json:"window"`
+	Buckets   int                 `json:"buckets"`
+	Upstream  string              `json:"upstream"`
+}
+type Config struct {
+	Addr       string        `json:"addr"`
+	Grace      Duration      `json:"grace"`
+	Policy     string        `json:"policy"`
+	Admin      string        `json:"admin"`
+	AdminToken string        `json:"admin_token"`
+	Routes     []RouteConfig `json:"routes"`
+}
+
+var routeKeys = []string{"algorithm", "limit", "rate", "per-second", "window", "buckets", "upstream", "name"}
+
+func (r *RouteConfig) set(key, value string) error {
+	var err error
+	switch key {
+	case "name":
+		r.Name = value
+	case "algorithm":
+		r.Algorithm = ratelimit.Algorithm(value)
+	case "limit":
+		r.Limit, err = strconv.Atoi(value)
+	case "rate":
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		r.Rate = Duration(d)
+	case "per-second":
+		r.PerSecond, err = strconv.ParseFloat(value, 64)
+	case "window":
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		r.Window = Duration(d)
+	case "buckets":
+		r.Buckets, err = strconv.Atoi(value)
+	case "upstream":
+		r.Upstream = value
+	default:
+		return fmt.Errorf("unknown route setting %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return nil
+}
+func (r RouteConfig) limiter() ratelimit.Config {
+	return ratelimit.Config{
+		Algorithm: r.Algorithm,
+		Limit:     r.Limit,
+		Rate:      time.Duration(r.Rate),
+		PerSecond: r.PerSecond,
+		Window:    time.Duration(r.Window),
+		Buckets:   r.Buckets,
+	}
+}
+func parseRoute(spec string, base RouteConfig) (RouteConfig, error) {
+	path, settings, _ := strings.Cut(spec, ":")
+	route := base
+	route.Path = path
+	if settings == "" {
+		return route, nil
+	}
+	for _, setting := range strings.Split(settings, ",") {
+		key, value, found := strings.Cut(setting, "=")
+		if !found {
+			return route, fmt.Errorf("route %s: expected key=value, got %q", path, setting)
+		}
+		if err := route.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return route, fmt.Errorf("route %s: %w", path, err)
+		}
+	}
+	return route, nil
+}
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := Config{Addr: ":8080", Grace: Duration(ratelimit.DefaultGracePeriod)}
+	base := RouteConfig{Path: "/", Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 10, Rate: Duration(time.Second), Window: Duration(time.Minute)}
+	fs := flag.NewFlagSet("ratelimiter", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("RATELIMIT_CONFIG"), "path to a JSON config file")
+	addr := fs.String("addr", "", "address to listen on")
+	policyPath := fs.String("policy", "", "path to a YAML or JSON policy file, replacing the route flags")
+	grace := fs.Duration("grace", 0, "how long to wait for in-flight requests on shutdown")
+	admin := fs.String("admin", "", "address of the admin API listener, authenticated with RATELIMIT_ADMIN_TOKEN (disabled when empty)")
+	var routeSpecs []string
+	fs.Func("route", "route spec such as /admin:algorithm=token-bucket,limit=5,rate=500ms (repeatable)", func(spec string) error {
+		routeSpecs = append(routeSpecs, spec)
+		return nil
+	})
+	baseFlags := make(map[string]*string)
+	for _, key := range routeKeys {
+		baseFlags[key] = fs.String(key, "", "default route "+key)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if *configPath != "" {
+		file, err := os.Open(*configPath)
+		if err != nil {
+			return cfg, err
+		}
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&cfg)
+		file.Close()
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", *configPath, err)
+		}
+	}
+	if value := getenv("RATELIMIT_ADDR"); value != "" {
+		cfg.Addr = value
+	}
+	if value := getenv("RATELIMIT_GRACE"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return cfg, fmt.Errorf("RATELIMIT_GRACE: %w", err)
+		}
+		cfg.Grace = Duration(d)
+	}
+	if value := getenv("RATELIMIT_POLICY"); value != "" {
+		cfg.Policy = value
+	}
+	if value := getenv("RATELIMIT_ADMIN"); value != "" {
+		cfg.Admin = value
+	}
+	if value := getenv("RATELIMIT_ADMIN_TOKEN"); value != "" {
+		cfg.AdminToken = value
+	}
+	for _, key := range routeKeys {
+		if value := getenv("RATELIMIT_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))); value != "" {
+			if err := base.set(key, value); err != nil {
+				return cfg, err
+			}
+		}
+	}
+	if value := getenv("RATELIMIT_ROUTES"); value != "" && len(routeSpecs) == 0 {
+		routeSpecs = strings.Split(value, ";")
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch {
+		case err != nil:
+		case f.Name == "addr":
+			cfg.Addr = *addr
+		case f.Name == "grace":
+			cfg.Grace = Duration(*grace)
+		case f.Name == "policy":
+			cfg.Policy = *policyPath
+		case f.Name == "admin":
+			cfg.Admin = *admin
+		case baseFlags[f.Name] != nil:
+			err = base.set(f.Name, *baseFlags[f.Name])
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+	if len(routeSpecs) > 0 {
+		cfg.Routes = nil
+		for _, spec := range routeSpecs {
+			route, err := parseRoute(strings.TrimSpace(spec), base)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.Routes = append(cfg.Routes, route)
+		}
+	}
+	if len(cfg.Routes) == 0 {
+		cfg.Routes = []RouteConfig{base}
+	}
+	return cfg, cfg.validate()
+}
+func (c Config) validate() error {
+	if c.Admin != "" && c.AdminToken == "" {
+		return fmt.Errorf("the admin API needs a token, set RATELIMIT_ADMIN_TOKEN or admin_token")
+	}
+	if c.Admin != "" && c.Admin == c.Addr {
+		return fmt.Errorf("the admin API must listen on its own address, got %s", c.Admin)
+	}
+	seen := make(map[string]bool)
+	for i, route := range c.Routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("route %d: path must start with /, got %q", i, route.Path)
+		}
+		if route.Path == "/metrics" {
+			return fmt.Errorf("route %d: /metrics is reserved", i)
+		}
+		if seen[route.Path] {
+			return fmt.Errorf("route %s is defined more than once", route.Path)
+		}
+		seen[route.Path] = true
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+module ratelimiter
+
+go 1.21.5
+
+require github.com/cdrcstcs/CV-RateLimiter/ratelimit v0.0.0
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+replace github.com/cdrcstcs/CV-RateLimiter/ratelimit => ../ratelimit
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit/policy"
+)
+
+const policyPollInterval = 5 * time.Second
+
+func allowedHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Request allowed\n")
+}
+func routeHandler(route RouteConfig) (http.HandlerFunc, error) {
+	if route.Upstream == "" {
+		return allowedHandler, nil
+	}
+	target, err := url.Parse(route.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: invalid upstream: %w", route.Path, err)
+	}
+	return httputil.NewSingleHostReverseProxy(target).ServeHTTP, nil
+}
+func newServer(cfg Config, opts ...ratelimit.Option) (*http.Server, *ratelimit.Registry, error) {
+	registry := ratelimit.NewRegistry()
+	mux := http.NewServeMux()
+	for _, route := range cfg.Routes {
+		metrics := &ratelimit.Metrics{}
+		limiter, err := ratelimit.New(route.limiter(), metrics, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %s: %w", route.Path, err)
+		}
+		name := route.Name
+		if name == "" {
+			name = route.Path
+		}
+		if err := registry.Register(ratelimit.Source{Name: name, Route: route.Path, Limiter: limiter, Metrics: metrics}); err != nil {
+			return nil, nil, err
+		}
+		next, err := routeHandler(route)
+		if err != nil {
+			return nil, nil, err
+		}
+		mux.HandleFunc(route.Path, ratelimit.RequestHandler(limiter, next, opts...))
+	}
+	mux.Handle("/metrics", registry)
+	return httpServer(cfg.Addr, mux), registry, nil
+}
+func newPolicyServer(cfg Config, opts ...ratelimit.Option) (*http.Server, *policy.Chain, error) {
+	p, err := policy.Load(cfg.Policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	next, err := routeHandler(cfg.Routes[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := p.Build(next, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", chain)
+	mux.Handle("/metrics", chain.MetricsHandler())
+	server := httpServer(cfg.Addr, mux)
+	server.RegisterOnShutdown(func() { chain.Close() })
+	return server, chain, nil
+}
+func httpServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+}
+func adminServer(cfg Config, sources func() []ratelimit.Source) *http.Server {
+	return httpServer(cfg.Admin, ratelimit.AdminHandler(sources, cfg.AdminToken))
+}
+func reportReload(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Policy reload failed, keeping the current policy:", err)
+		return
+	}
+	fmt.Println("Policy reloaded")
+}
+func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var server *http.Server
+	var chain *policy.Chain
+	var sources func() []ratelimit.Source
+	if cfg.Policy != "" {
+		server, chain, err = newPolicyServer(cfg)
+		sources = func() []ratelimit.Source { return chain.Registry().Sources() }
+	} else {
+		var registry *ratelimit.Registry
+		server, registry, err = newServer(cfg)
+		sources = registry.Sources
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+	if chain != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go chain.Watch(ctx, cfg.Policy, policyPollInterval, hup, ratelimit.RealClock{}, reportReload)
+		fmt.Printf("Limiting requests with policy %s (reload with SIGHUP)\n", cfg.Policy)
+	} else {
+		for _, route := range cfg.Routes {
+			fmt.Printf("Limiting %s with %s (limit %d)\n", route.Path, route.Algorithm, route.Limit)
+		}
+	}
+	if cfg.Admin != "" {
+		go func() {
+			if err := ratelimit.ListenAndServe(ctx, adminServer(cfg, sources), time.Duration(cfg.Grace)); err != nil {
+				fmt.Println("Admin server failed:", err)
+			}
+		}()
+		fmt.Printf("Admin API is running on %s\n", cfg.Admin)
+	}
+	fmt.Printf("Server is running on %s\n", cfg.Addr)
+	if err := ratelimit.ListenAndServe(ctx, server, time.Duration(cfg.Grace)); err != nil {
+		fmt.Println("Server failed:", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := loadConfig(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8080" || time.Duration(cfg.Grace) != ratelimit.DefaultGracePeriod {
+		t.Errorf("unexpected server defaults: %+v", cfg)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].Path != "/" || cfg.Routes[0].Algorithm != ratelimit.AlgorithmTokenBucket || cfg.Routes[0].Limit != 10 {
+		t.Errorf("unexpected default route: %+v", cfg.Routes)
+	}
+}
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{"addr": ":9000", "grace": "30s", "routes": [{"path": "/a", "algorithm": "leaky-bucket", "limit": 3, "rate": "1s"}]}`
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(nil, env(map[string]string{"RATELIMIT_CONFIG": path, "RATELIMIT_GRACE": "5s"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9000" || time.Duration(cfg.Grace) != 5*time.Second || len(cfg.Routes) != 1 || cfg.Routes[0].Algorithm != ratelimit.AlgorithmLeakyBucket {
+		t.Errorf("expected the file to be loaded with env overrides, got %+v", cfg)
+	}
+	cfg, err = loadConfig([]string{"-addr", ":7000", "-limit", "7", "-route", "/x", "-route", "/y:algorithm=fixed-window-counter,window=1s"}, env(map[string]string{
+		"RATELIMIT_CONFIG": path,
+		"RATELIMIT_ADDR":   ":6000",
+		"RATELIMIT_LIMIT":  "4",
+		"RATELIMIT_RATE":   "250ms",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":7000" {
+		t.Errorf("expected flags to override the environment, got %s", cfg.Addr)
+	}
+	want := []RouteConfig{
+		{Path: "/x", Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 7, Rate: Duration(250 * time.Millisecond), Window: Duration(time.Minute)},
+		{Path: "/y", Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 7, Rate: Duration(250 * time.Millisecond), Window: Duration(time.Second)},
+	}
+	if fmt.Sprint(cfg.Routes) != fmt.Sprint(want) {
+		t.Errorf("expected routes %+v, got %+v", want, cfg.Routes)
+	}
+}
+func TestLoadConfig_Invalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-route", "/a", "-route", "/a"},
+		{"-route", "metrics"},
+		{"-route", "/metrics"},
+		{"-route", "/a:limit=many"},
+		{"-route", "/a:burst"},
+		{"-config", filepath.Join(t.TempDir(), "missing.json")},
+		{"-admin", ":9090"},
+	} {
+		if _, err := loadConfig(args, env(nil)); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+	if _, err := loadConfig([]string{"-admin", ":8080"}, env(map[string]string{"RATELIMIT_ADMIN_TOKEN": "secret"})); err == nil {
+		t.Error("expected the admin API to need its own listener")
+	}
+	cfg, err := loadConfig(nil, env(map[string]string{"RATELIMIT_ADMIN": ":9090", "RATELIMIT_ADMIN_TOKEN": "secret"}))
+	if err != nil || cfg.Admin != ":9090" || cfg.AdminToken != "secret" {
+		t.Errorf("expected the admin API to be configured from the environment, got %+v, %v", cfg, err)
+	}
+}
+func TestNewServer(t *testing.T) {
+	cfg, err := loadConfig([]string{
+		"-route", "/tokens:algorithm=token-bucket,limit=2,rate=1m",
+		"-route", "/windows:algorithm=sliding-window-counter,limit=1,window=1m,buckets=6",
+	}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, registry, err := newServer(cfg, ratelimit.WithClock(ratelimit.NewManualClock(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := map[string][]int{}
+	for _, path := range []string{"/tokens", "/tokens", "/tokens", "/windows", "/windows"} {
+		rr := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		codes[path] = append(codes[path], rr.Code)
+	}
+	if fmt.Sprint(codes["/tokens"]) != "[200 200 429]" || fmt.Sprint(codes["/windows"]) != "[200 429]" {
+		t.Errorf("expected each route to use its own limiter, got %v", codes)
+	}
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics?format=json", nil))
+	var snapshot ratelimit.MetricsSnapshot
+	if err := json.NewDecoder(rr.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Allowed != 3 || snapshot.Rejected != 2 || len(snapshot.Limiters) != 2 {
+		t.Errorf("unexpected metrics: %+v", snapshot)
+	}
+	admin := adminServer(Config{Admin: ":9090", AdminToken: "secret"}, registry.Sources)
+	rr = httptest.NewRecorder()
+	admin.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/limiters", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the admin API to require the token, got %d", rr.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/limiters/%2Ftokens", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	admin.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"limit":2`) {
+		t.Errorf("expected the admin API to inspect the route limiters, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+func TestNewServer_Upstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "upstream %s\n", r.URL.Path)
+	}))
+	defer upstream.Close()
+	server, _, err := newServer(Config{Routes: []RouteConfig{{Path: "/api/", Algorithm: ratelimit.AlgorithmGCRA, Limit: 1, PerSecond: 1, Upstream: upstream.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "upstream /api/users\n" {
+		t.Errorf("expected the request to be proxied, got %d %q", rr.Code, rr.Body.String())
+	}
+	if _, _, err := newServer(Config{Routes: []RouteConfig{{Path: "/", Algorithm: "bogus", Limit: 1}}}); err == nil {
+		t.Error("expected an invalid algorithm to be rejected")
+	}
+}
+func TestNewServer_Policy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	data := "rules:\n  - path: /api/\n    key: header:X-API-Key\n    algorithm: token-bucket\n    rate: 1/m\n    burst: 1\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(nil, env(map[string]string{"RATELIMIT_POLICY": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _, err := newPolicyServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+	var codes []int
+	for _, key := range []string{"a", "a", "b", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	if fmt.Sprint(codes) != "[200 429 200 400]" {
+		t.Errorf("expected the policy to limit per API key, got %v", codes)
+	}
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rr.Body.String(), "Rejected requests: 1\n") {
+		t.Errorf("unexpected metrics: %q", rr.Body.String())
+	}
+	if _, _, err := newPolicyServer(Config{Policy: filepath.Join(t.TempDir(), "missing.yaml"), Routes: cfg.Routes}); err == nil {
+		t.Error("expected a missing policy file to be rejected")
+	}
+}
@@ -1,3 +0,0 @@
-module fixed-window-counter
-
-go 1.21.5
@@ -1,3 +0,0 @@
-module leaky-bucket
-
-go 1.21.5
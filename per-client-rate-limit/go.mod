@@ -1,5 +0,0 @@
-module per-client-rate-limit
-
-go 1.21.5
-
-require golang.org/x/time v0.6.0 
@@ -0,0 +1,398 @@
+package ratelimit
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type State struct {
+	Limit   int           `json:"limit"`
+	Rate    Rate          `json:"rate,omitempty"`
+	Window  time.Duration `json:"window,omitempty"`
+	Gauge   string        `json:"gauge"`
+	Level   float64       `json:"level"`
+	Entries int           `json:"entries,omitempty"`
+}
+type inspector interface {
+	state() State
+}
+type crediter interface {
+	credit(n int)
+}
+
+func Inspect(l Limiter) (State, bool) {
+	if i, ok := l.(inspector); ok {
+		return i.state(), true
+	}
+	return State{}, false
+}
+func Credit(l Limiter, n int) bool {
+	c, ok := l.(crediter)
+	if ok && n > 0 {
+		c.credit(n)
+	}
+	return ok
+}
+
+type Adjustment struct {
+	Rate     *Rate  `json:"rate,omitempty"`
+	Capacity *int   `json:"capacity,omitempty"`
+	Window   string `json:"window,omitempty"`
+}
+
+func (a Adjustment) window() (time.Duration, error) {
+	if a.Window == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(a.Window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("window must be a positive duration, got %q", a.Window)
+	}
+	return d, nil
+}
+func (a Adjustment) check(l Limiter) error {
+	if a.Rate == nil && a.Capacity == nil && a.Window == "" {
+		return errors.New("adjustment needs a rate, capacity or window")
+	}
+	if a.Rate != nil {
+		if _, ok := l.(interface{ SetRate(Rate) }); !ok || *a.Rate <= 0 {
+			return fmt.Errorf("cannot set rate %v on %T", *a.Rate, l)
+		}
+	}
+	if a.Capacity != nil {
+		if _, ok := l.(interface{ SetCapacity(int) error }); !ok || *a.Capacity <= 0 {
+			return fmt.Errorf("cannot set capacity %d on %T", *a.Capacity, l)
+		}
+	}
+	if a.Window != "" {
+		if _, ok := l.(interface{ SetWindow(time.Duration) }); !ok {
+			return fmt.Errorf("cannot set window on %T", l)
+		}
+	}
+	_, err := a.window()
+	return err
+}
+func (a Adjustment) apply(l Limiter) {
+	if s, ok := l.(interface{ SetRate(Rate) }); ok && a.Rate != nil {
+		s.SetRate(*a.Rate)
+	}
+	if s, ok := l.(interface{ SetCapacity(int) error }); ok && a.Capacity != nil {
+		s.SetCapacity(*a.Capacity)
+	}
+	if s, ok := l.(interface{ SetWindow(time.Duration) }); ok && a.Window != "" {
+		window, _ := a.window()
+		s.SetWindow(window)
+	}
+}
+func Adjust(l Limiter, a Adjustment) error {
+	if err := a.check(l); err != nil {
+		return err
+	}
+	a.apply(l)
+	return nil
+}
+func (a Adjustment) merge(next Adjustment) Adjustment {
+	if next.Rate != nil {
+		a.Rate = next.Rate
+	}
+	if next.Capacity != nil {
+		a.Capacity = next.Capacity
+	}
+	if next.Window != "" {
+		a.Window = next.Window
+	}
+	return a
+}
+
+// Adjust applies a to every key and records it, together with any earlier
+// adjustments, in a layer above the factory: new keys start adjusted, and a
+// later Reconfigure re-applies the layer after updating each limiter.
+func (k *KeyedLimiter) Adjust(a Adjustment) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	cfg := *k.config.Load()
+	if err := a.check(cfg.factory(overflowKey)); err != nil {
+		return err
+	}
+	cfg.adjustment = cfg.adjustment.merge(a)
+	k.config.Store(&cfg)
+	k.update(func(key string, l Limiter) Limiter {
+		a.apply(l)
+		cfg.keys[key].apply(l)
+		return l
+	})
+	return nil
+}
+func (k *KeyedLimiter) AdjustKey(key string, a Adjustment) error {
+	l, found := k.Peek(key)
+	if !found {
+		return fmt.Errorf("key %q is not tracked", key)
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if err := a.check(l); err != nil {
+		return err
+	}
+	cfg := *k.config.Load()
+	keys := make(map[string]Adjustment, len(cfg.keys)+1)
+	for other, adjustment := range cfg.keys {
+		keys[other] = adjustment
+	}
+	keys[key] = keys[key].merge(a)
+	cfg.keys = keys
+	k.config.Store(&cfg)
+	a.apply(l)
+	return nil
+}
+func (k *KeyedLimiter) forget(key string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	cfg := *k.config.Load()
+	if _, found := cfg.keys[key]; !found {
+		return
+	}
+	keys := make(map[string]Adjustment, len(cfg.keys))
+	for other, adjustment := range cfg.keys {
+		if other != key {
+			keys[other] = adjustment
+		}
+	}
+	cfg.keys = keys
+	k.config.Store(&cfg)
+}
+func (k *KeyedLimiter) Adjustment() Adjustment {
+	return k.config.Load().adjustment
+}
+func (k *KeyedLimiter) KeyAdjustment(key string) (Adjustment, bool) {
+	a, found := k.config.Load().keys[key]
+	return a, found
+}
+
+type LimiterInfo struct {
+	Name       string      `json:"name"`
+	Route      string      `json:"route,omitempty"`
+	State      *State      `json:"state,omitempty"`
+	Keys       *KeyedStats `json:"keys,omitempty"`
+	Adjustment *Adjustment `json:"adjustment,omitempty"`
+}
+type KeyInfo struct {
+	Key         string      `json:"key"`
+	Tracked     bool        `json:"tracked"`
+	State       *State      `json:"state,omitempty"`
+	Banned      bool        `json:"banned"`
+	BannedUntil *time.Time  `json:"banned_until,omitempty"`
+	Adjustment  *Adjustment `json:"adjustment,omitempty"`
+}
+
+func limiterInfo(source Source) LimiterInfo {
+	info := LimiterInfo{Name: source.Name, Route: source.Route}
+	if state, ok := Inspect(source.Limiter); ok {
+		info.State = &state
+	}
+	if source.Keyed != nil {
+		stats := source.Keyed.Stats()
+		info.Keys = &stats
+		if a := source.Keyed.Adjustment(); a != (Adjustment{}) {
+			info.Adjustment = &a
+		}
+	}
+	return info
+}
+func keyInfo(k *KeyedLimiter, key string) KeyInfo {
+	info := KeyInfo{Key: key}
+	if l, found := k.Peek(key); found {
+		info.Tracked = true
+		if state, ok := Inspect(l); ok {
+			info.State = &state
+		}
+	}
+	if until, found := k.Banned(key); found {
+		info.Banned = true
+		if !until.IsZero() {
+			info.BannedUntil = &until
+		}
+	}
+	if a, found := k.KeyAdjustment(key); found {
+		info.Adjustment = &a
+	}
+	return info
+}
+
+type banRequest struct {
+	Duration string `json:"duration"`
+}
+
+func AdminHandler(sources func() []Source, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ratelimit-admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		path, found := strings.CutPrefix(r.URL.EscapedPath(), "/limiters")
+		if !found || (path != "" && !strings.HasPrefix(path, "/")) {
+			http.NotFound(w, r)
+			return
+		}
+		var parts []string
+		for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+			part, err := url.PathUnescape(part)
+			if err != nil {
+				http.Error(w, "Invalid path", http.StatusBadRequest)
+				return
+			}
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			if !allowMethods(w, r, http.MethodGet) {
+				return
+			}
+			infos := []LimiterInfo{}
+			for _, source := range sources() {
+				infos = append(infos, limiterInfo(source))
+			}
+			writeJSON(w, http.StatusOK, infos)
+			return
+		}
+		source, found := lookup(sources(), parts[0])
+		if !found {
+			http.Error(w, fmt.Sprintf("Unknown limiter %q", parts[0]), http.StatusNotFound)
+			return
+		}
+		switch {
+		case len(parts) == 1:
+			serveLimiter(w, r, source)
+		case len(parts) >= 3 && len(parts) <= 4 && parts[1] == "keys" && source.Keyed != nil:
+			if len(parts) == 4 && parts[3] == "ban" {
+				serveBan(w, r, source.Keyed, parts[2])
+			} else if len(parts) == 3 {
+				serveKey(w, r, source.Keyed, parts[2])
+			} else {
+				http.NotFound(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+func serveLimiter(w http.ResponseWriter, r *http.Request, source Source) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+	if r.Method == http.MethodPatch {
+		var a Adjustment
+		if !readJSON(w, r, &a) {
+			return
+		}
+		var err error
+		if source.Keyed != nil {
+			err = source.Keyed.Adjust(a)
+		} else {
+			err = Adjust(source.Limiter, a)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, limiterInfo(source))
+}
+func serveKey(w http.ResponseWriter, r *http.Request, k *KeyedLimiter, key string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPatch:
+		var a Adjustment
+		if !readJSON(w, r, &a) {
+			return
+		}
+		if _, found := k.Peek(key); !found {
+			http.Error(w, fmt.Sprintf("Key %q is not tracked", key), http.StatusNotFound)
+			return
+		}
+		if err := k.AdjustKey(key, a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		k.Reset(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, keyInfo(k, key))
+}
+func serveBan(w http.ResponseWriter, r *http.Request, k *KeyedLimiter, key string) {
+	if !allowMethods(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if !k.Unban(key) {
+			http.Error(w, fmt.Sprintf("Key %q is not banned", key), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var ban banRequest
+	if r.ContentLength != 0 && !readJSON(w, r, &ban) {
+		return
+	}
+	var d time.Duration
+	if ban.Duration != "" {
+		var err error
+		if d, err = time.ParseDuration(ban.Duration); err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("duration must be a positive duration, got %q", ban.Duration), http.StatusBadRequest)
+			return
+		}
+	}
+	k.Ban(key, d)
+	writeJSON(w, http.StatusOK, keyInfo(k, key))
+}
+func authorized(r *http.Request, token string) bool {
+	scheme, credentials, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if token == "" || !found || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), []byte(token)) == 1
+}
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+func lookup(sources []Source, name string) (Source, bool) {
+	for _, source := range sources {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return Source{}, false
+}
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+func TestSetters(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(4, time.Second, nil, WithClock(clock))
+	bucket.AllowN(4)
+	bucket.SetRate(10)
+	clock.Advance(100 * time.Millisecond)
+	if state, _ := Inspect(bucket); state.Level != 1 || state.Rate != 10 {
+		t.Errorf("expected the new rate to apply from now on, got %+v", state)
+	}
+	gcra := NewAtomicTokenBucket(4, time.Second, nil, WithClock(clock))
+	gcra.AllowN(4)
+	gcra.SetRate(10)
+	clock.Advance(100 * time.Millisecond)
+	if state, _ := Inspect(gcra); state.Level < 1-tokenEpsilon || state.Level > 1+tokenEpsilon || state.Rate != 10 {
+		t.Errorf("expected the new rate to apply to the gcra bucket from now on, got %+v", state)
+	}
+	gcra.SetCapacity(8)
+	if d := gcra.AllowN(2); !d.Allowed || d.Remaining != 0 || d.Limit != 8 {
+		t.Errorf("expected the gcra level to be rescaled to the new capacity, got %+v", d)
+	}
+	leaky := NewLeakyBucket(4, time.Second, nil, WithClock(clock))
+	leaky.AllowN(2)
+	leaky.SetCapacity(2)
+	if state, _ := Inspect(leaky); state.Level != 1 || state.Limit != 2 || state.Gauge != "water" {
+		t.Errorf("expected the water level to be rescaled, got %+v", state)
+	}
+	log := NewSlidingWindowLog(2, time.Minute, nil, WithClock(clock))
+	log.AllowN(2)
+	log.SetCapacity(3)
+	if !log.Allow() || log.Allow() {
+		t.Error("expected the log to admit exactly one more request")
+	}
+	if state, _ := Inspect(log); state.Level != 3 || state.Entries != 2 {
+		t.Errorf("unexpected log state: %+v", state)
+	}
+	log.SetWindow(time.Second)
+	clock.Advance(2 * time.Second)
+	if !log.Allow() {
+		t.Error("expected a shorter window to expire the log sooner")
+	}
+	counter := NewSlidingWindowCounter(1, time.Minute, 6, nil, WithClock(clock))
+	counter.Allow()
+	counter.SetCapacity(2)
+	if !counter.Allow() {
+		t.Error("expected a raised capacity to admit another request")
+	}
+	counter.SetRate(1)
+	if state, _ := Inspect(counter); state.Window != 2*time.Second || state.Level != 2 {
+		t.Errorf("expected the rate to set a window of limit/rate and keep the counts, got %+v", state)
+	}
+	if _, ok := Inspect(refusal{}); ok {
+		t.Error("expected a refusal to have no state")
+	}
+}
+func TestCredit(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	for _, cfg := range []Config{
+		{Algorithm: AlgorithmTokenBucket, Limit: 3, Rate: time.Hour},
+		{Algorithm: AlgorithmLeakyBucket, Limit: 3, Rate: time.Hour},
+		{Algorithm: AlgorithmGCRA, Limit: 3, Rate: time.Hour},
+		{Algorithm: AlgorithmFixedWindowCounter, Limit: 3, Window: time.Hour},
+		{Algorithm: AlgorithmSlidingWindowCounter, Limit: 3, Window: time.Hour, Buckets: 6},
+		{Algorithm: AlgorithmSlidingWindowLog, Limit: 3, Window: time.Hour},
+	} {
+		l, _ := New(cfg, nil, WithClock(clock))
+		l.Allow()
+		l.AllowN(2)
+		if !Credit(l, 2) || !l.AllowN(2).Allowed || l.Allow() {
+			t.Errorf("%s: expected exactly the credited tokens to be reusable", cfg.Algorithm)
+		}
+		if Credit(l, 10); !l.AllowN(3).Allowed || l.Allow() {
+			t.Errorf("%s: expected credit to be capped at the limit", cfg.Algorithm)
+		}
+	}
+	hybrid, _ := NewHybridLimiter(&budgetBackend{limit: 1}, "a", Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 1, Window: time.Hour}, 1, 0, nil)
+	if Credit(hybrid, 1) {
+		t.Error("expected a limiter without local state to refuse credit")
+	}
+}
+func TestKeyedLimiter_Ban(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(2, time.Second, nil, WithClock(clock))
+	}, time.Hour, WithClock(clock))
+	defer store.Close()
+	store.AllowN("a", 2)
+	if !store.Reset("a") || store.Reset("a") || !store.Allow("a") {
+		t.Error("expected a reset key to start with a fresh limiter")
+	}
+	store.Ban("a", time.Minute)
+	if d := store.AllowN("a", 1); d.Allowed || d.RetryAfter != time.Minute {
+		t.Errorf("expected a banned key to be refused, got %+v", d)
+	}
+	if err := store.Wait(context.Background(), "a", 1); err != ErrKeyBanned {
+		t.Errorf("expected ErrKeyBanned, got %v", err)
+	}
+	clock.Advance(time.Minute)
+	if _, banned := store.Banned("a"); banned || !store.Allow("a") {
+		t.Error("expected the ban to expire")
+	}
+	store.Ban("b", 0)
+	if store.Stats().Banned != 1 || store.Allow("b") || !store.Unban("b") || !store.Allow("b") {
+		t.Error("expected a permanent ban to hold until lifted")
+	}
+}
+func TestAdminHandler(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	global := NewTokenBucket(10, time.Second, nil, WithClock(clock))
+	clients := NewKeyedLimiter(func(key string) Limiter {
+		counter, _ := NewFixedWindowCounter(2, time.Minute, nil, WithClock(clock))
+		return counter
+	}, time.Hour, WithClock(clock))
+	defer clients.Close()
+	reg := NewRegistry()
+	reg.Register(Source{Name: "global", Limiter: global})
+	reg.Register(Source{Name: "clients", Route: "/", Keyed: clients})
+	h := AdminHandler(reg.Sources, "secret")
+	req := httptest.NewRequest(http.MethodGet, "/limiters", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong token to be refused, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	AdminHandler(reg.Sources, "").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unset token to refuse every request, got %d", rec.Code)
+	}
+	var infos []LimiterInfo
+	if err := json.Unmarshal(adminRequest(h, http.MethodGet, "/limiters", "").Body.Bytes(), &infos); err != nil || len(infos) != 2 || infos[0].State.Limit != 10 {
+		t.Fatalf("unexpected limiter list: %+v, %v", infos, err)
+	}
+	if rec := adminRequest(h, http.MethodPatch, "/limiters/global", `{"rate": 5, "capacity": 20}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"limit":20,"rate":5`) {
+		t.Errorf("expected the global limiter to be adjusted, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := adminRequest(h, http.MethodPatch, "/limiters/global", `{"window": "1m"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a window change on a token bucket to be rejected, got %d", rec.Code)
+	}
+	clients.AllowN("203.0.113.0/24", 2)
+	rec = adminRequest(h, http.MethodGet, "/limiters/clients/keys/203.0.113.0%2F24", "")
+	var key KeyInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &key); err != nil || !key.Tracked || key.State.Level != 2 {
+		t.Fatalf("expected the key state to be readable, got %s", rec.Body.String())
+	}
+	if rec := adminRequest(h, http.MethodPatch, "/limiters/clients", `{"capacity": 3}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the keyed limiter to be adjusted, got %d %s", rec.Code, rec.Body.String())
+	}
+	if !clients.Allow("203.0.113.0/24") || clients.AllowN("fresh", 3).Limit != 3 {
+		t.Error("expected the adjustment to apply to existing and new keys")
+	}
+	if rec := adminRequest(h, http.MethodPatch, "/limiters/clients/keys/fresh", `{"capacity": 5}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"adjustment":{"capacity":5}`) {
+		t.Errorf("expected the key adjustment to be reported, got %d %s", rec.Code, rec.Body.String())
+	}
+	clients.Reconfigure(func(key string) Limiter {
+		counter, _ := NewFixedWindowCounter(2, time.Minute, nil, WithClock(clock))
+		return counter
+	}, func(key string, l Limiter) Limiter {
+		l.(*FixedWindowCounter).SetCapacity(2)
+		return l
+	})
+	if clients.AllowN("203.0.113.0/24", 1).Limit != 3 || clients.AllowN("fresh", 1).Limit != 5 || clients.AllowN("new", 1).Limit != 3 {
+		t.Error("expected admin adjustments to survive a reconfigure")
+	}
+	if rec := adminRequest(h, http.MethodGet, "/limiters/clients", ""); !strings.Contains(rec.Body.String(), `"adjustment":{"capacity":3}`) {
+		t.Errorf("expected the limiter adjustment to be reported, got %s", rec.Body.String())
+	}
+	if rec := adminRequest(h, http.MethodPut, "/limiters/clients/keys/fresh/ban", `{"duration": "10m"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"banned":true`) {
+		t.Errorf("expected the key to be banned, got %d %s", rec.Code, rec.Body.String())
+	}
+	if clients.Allow("fresh") {
+		t.Error("expected the banned key to be refused")
+	}
+	if rec := adminRequest(h, http.MethodDelete, "/limiters/clients/keys/fresh/ban", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected the ban to be lifted, got %d", rec.Code)
+	}
+	if _, banned := clients.Banned("fresh"); banned {
+		t.Error("expected the key to no longer be banned")
+	}
+	if rec := adminRequest(h, http.MethodDelete, "/limiters/clients/keys/fresh", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected the key to be reset, got %d", rec.Code)
+	}
+	if _, found := clients.KeyAdjustment("fresh"); found || clients.AllowN("fresh", 1).Limit != 3 {
+		t.Error("expected a reset to drop the key adjustment")
+	}
+	if rec := adminRequest(h, http.MethodDelete, "/limiters/clients/keys/203.0.113.0%2F24", ""); rec.Code != http.StatusNoContent || clients.Len() != 2 {
+		t.Errorf("expected the key to be reset, got %d", rec.Code)
+	}
+	for target, code := range map[string]int{"/limiters/missing": http.StatusNotFound, "/limiters/global/keys/a": http.StatusNotFound, "/other": http.StatusNotFound} {
+		if rec := adminRequest(h, http.MethodGet, target, ""); rec.Code != code {
+			t.Errorf("expected %d for %s, got %d", code, target, rec.Code)
+		}
+	}
+	if rec := adminRequest(h, http.MethodPost, "/limiters", ""); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("expected a POST to the list to be refused, got %d", rec.Code)
+	}
+}
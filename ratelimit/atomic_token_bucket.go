@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type gcraParams struct {
+	capacity int
+	rate     Rate
+	tau      time.Duration
+}
+type AtomicTokenBucket struct {
+	params  atomic.Pointer[gcraParams]
+	epoch   time.Time
+	tat     atomic.Int64
+	mutex   sync.Mutex
+	metrics *Metrics
+	clock   Clock
+}
+
+func NewAtomicTokenBucket(capacity int, rate time.Duration, metrics *Metrics, opts ...Option) *AtomicTokenBucket {
+	return NewAtomicTokenBucketRate(capacity, Every(rate), metrics, opts...)
+}
+func NewAtomicTokenBucketRate(capacity int, rate Rate, metrics *Metrics, opts ...Option) *AtomicTokenBucket {
+	o := buildOptions(opts)
+	b := &AtomicTokenBucket{
+		epoch:   o.clock.Now(),
+		metrics: metrics,
+		clock:   o.clock,
+	}
+	b.params.Store(newGCRAParams(capacity, rate))
+	return b
+}
+func newGCRAParams(capacity int, rate Rate) *gcraParams {
+	return &gcraParams{capacity: capacity, rate: rate, tau: rate.durationFor(float64(capacity))}
+}
+func (b *AtomicTokenBucket) elapsed(now time.Time) int64 {
+	return int64(max(now.Sub(b.epoch), 0))
+}
+func (p *gcraParams) tokens(now, tat int64) float64 {
+	if tat <= now {
+		return float64(p.capacity)
+	}
+	return min(max(p.rate.tokensFor(p.tau-time.Duration(max(tat-now, 0))), 0), float64(p.capacity))
+}
+func (p *gcraParams) increment(n int) int64 {
+	return int64(float64(n) / float64(p.rate) * float64(time.Second))
+}
+func (p *gcraParams) schedule(now, tat int64, n int) (int64, time.Duration) {
+	if n < 0 || n > p.capacity || p.rate <= 0 {
+		return tat, InfDuration
+	}
+	next := max(tat, now) + p.increment(n)
+	return next, max(time.Duration(next-now)-p.tau, 0)
+}
+func (b *AtomicTokenBucket) Allow() bool {
+	return b.AllowN(1).Allowed
+}
+func (b *AtomicTokenBucket) AllowN(n int) Decision {
+	start := time.Now()
+	nowTime := b.clock.Now()
+	now := b.elapsed(nowTime)
+	p := b.params.Load()
+	d := Decision{Limit: p.capacity, Window: p.tau}
+	for {
+		tat := b.tat.Load()
+		next, wait := p.schedule(now, tat, n)
+		if wait > 0 {
+			d.RetryAfter = wait
+			d.Remaining = int(p.tokens(now, tat) + tokenEpsilon)
+			d.ResetAt = b.epoch.Add(time.Duration(max(tat, now)))
+			break
+		}
+		if b.tat.CompareAndSwap(tat, next) {
+			d.Allowed = true
+			d.Remaining = int(p.tokens(now, next) + tokenEpsilon)
+			d.ResetAt = b.epoch.Add(time.Duration(next))
+			break
+		}
+	}
+	if d.ResetAt.Before(nowTime) {
+		d.ResetAt = nowTime
+	}
+	b.metrics.recordAtomic(d.Allowed, start)
+	return d
+}
+func (b *AtomicTokenBucket) Reserve(n int) *Reservation {
+	return b.reserveN(b.clock.Now(), n, InfDuration)
+}
+func (b *AtomicTokenBucket) Wait(ctx context.Context, n int) error {
+	return waitN(ctx, b.clock, b.metrics, b.reserveN, n)
+}
+func (b *AtomicTokenBucket) reserveN(nowTime time.Time, n int, maxWait time.Duration) *Reservation {
+	start := time.Now()
+	now := b.elapsed(nowTime)
+	p := b.params.Load()
+	r := &Reservation{limit: p.capacity, tokens: n, clock: b.clock}
+	for {
+		tat := b.tat.Load()
+		next, wait := p.schedule(now, tat, n)
+		if wait > maxWait || wait == InfDuration {
+			break
+		}
+		if b.tat.CompareAndSwap(tat, next) {
+			r.ok = true
+			r.timeToAct = nowTime.Add(wait)
+			var canceled atomic.Bool
+			r.refund = func(now time.Time) {
+				if !now.After(r.timeToAct) && canceled.CompareAndSwap(false, true) {
+					b.cancel(r, now)
+				}
+			}
+			break
+		}
+	}
+	b.metrics.recordAtomic(r.ok, start)
+	return r
+}
+func (b *AtomicTokenBucket) cancel(r *Reservation, nowTime time.Time) {
+	now := b.elapsed(nowTime)
+	refund := b.params.Load().increment(r.tokens)
+	for {
+		tat := b.tat.Load()
+		if b.tat.CompareAndSwap(tat, max(tat-refund, now)) {
+			return
+		}
+	}
+}
+func (b *AtomicTokenBucket) level() (string, float64) {
+	now := b.elapsed(b.clock.Now())
+	return "ratelimit_tokens", b.params.Load().tokens(now, b.tat.Load())
+}
+func (b *AtomicTokenBucket) reconfigure(cfg Config) bool {
+	if cfg.Algorithm != AlgorithmGCRA {
+		return false
+	}
+	b.rescale(func(p *gcraParams) (int, Rate) { return cfg.Limit, cfg.TokenRate() })
+	return true
+}
+func (b *AtomicTokenBucket) SetRate(rate Rate) {
+	b.rescale(func(p *gcraParams) (int, Rate) { return p.capacity, rate })
+}
+func (b *AtomicTokenBucket) SetCapacity(capacity int) error {
+	if capacity <= 0 {
+		return ErrInvalidLimit
+	}
+	b.rescale(func(p *gcraParams) (int, Rate) { return capacity, p.rate })
+	return nil
+}
+func (b *AtomicTokenBucket) rescale(update func(*gcraParams) (int, Rate)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.elapsed(b.clock.Now())
+	old := b.params.Load()
+	p := newGCRAParams(update(old))
+	for {
+		tat := b.tat.Load()
+		level := old.tokens(now, tat)
+		if old.capacity > 0 {
+			level = level * float64(p.capacity) / float64(old.capacity)
+		}
+		if b.tat.CompareAndSwap(tat, now+int64(p.tau-p.rate.durationFor(level))) {
+			break
+		}
+	}
+	b.params.Store(p)
+}
+func (b *AtomicTokenBucket) credit(n int) {
+	b.cancel(&Reservation{tokens: n}, b.clock.Now())
+}
+func (b *AtomicTokenBucket) state() State {
+	now := b.elapsed(b.clock.Now())
+	p := b.params.Load()
+	return State{Limit: p.capacity, Rate: p.rate, Gauge: "tokens", Level: p.tokens(now, b.tat.Load())}
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAtomicTokenBucket_Decision(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	metrics := &Metrics{}
+	bucket := NewAtomicTokenBucket(2, time.Second, metrics, WithClock(clock))
+	if d := bucket.AllowN(2); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected the initial burst to be allowed, got %+v", d)
+	}
+	clock.Advance(400 * time.Millisecond)
+	d := bucket.AllowN(1)
+	if d.Allowed || d.RetryAfter != 600*time.Millisecond {
+		t.Fatalf("expected a rejection retryable in 600ms, got %+v", d)
+	}
+	if !d.ResetAt.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("ResetAt = %v, want %v", d.ResetAt, start.Add(2*time.Second))
+	}
+	if d := bucket.AllowN(3); d.RetryAfter != InfDuration {
+		t.Errorf("expected a request larger than capacity to never be retryable, got %v", d.RetryAfter)
+	}
+	if allowed, rejected := metrics.Counts(); allowed != 1 || rejected != 2 {
+		t.Errorf("expected 1 allowed and 2 rejected, got %d and %d", allowed, rejected)
+	}
+}
+func TestAtomicTokenBucket_MatchesTokenBucket(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	locked := NewTokenBucketRate(5, 4, nil, WithClock(clock))
+	atomic := NewAtomicTokenBucketRate(5, 4, nil, WithClock(clock))
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		n := rng.Intn(3) + 1
+		want, got := locked.AllowN(n), atomic.AllowN(n)
+		if want.Allowed != got.Allowed || want.Remaining != got.Remaining || (want.RetryAfter-got.RetryAfter).Abs() > time.Microsecond {
+			t.Fatalf("step %d: expected %+v, got %+v", i, want, got)
+		}
+		clock.Advance(time.Duration(rng.Intn(400)) * time.Millisecond)
+	}
+}
+func TestAtomicTokenBucket_Concurrent(t *testing.T) {
+	bucket := NewAtomicTokenBucket(100, time.Hour, nil, WithClock(NewManualClock(time.Now())))
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if bucket.Allow() {
+					mutex.Lock()
+					allowed++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 100 {
+		t.Errorf("expected exactly 100 requests to be allowed, got %d", allowed)
+	}
+}
+func TestAtomicTokenBucket_Reserve(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewAtomicTokenBucket(2, time.Second, nil, WithClock(clock))
+	if r := bucket.Reserve(2); !r.OK() || r.Delay() != 0 {
+		t.Fatalf("expected an immediate reservation, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	r := bucket.Reserve(1)
+	if !r.OK() || r.Delay() != time.Second {
+		t.Fatalf("expected a reservation one second out, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	r.Cancel()
+	r.Cancel()
+	if again := bucket.Reserve(1); again.Delay() != time.Second {
+		t.Errorf("expected cancelled tokens to be refunded once, got delay %v", again.Delay())
+	}
+	if r := bucket.Reserve(3); r.OK() {
+		t.Errorf("expected a reservation larger than capacity to fail")
+	}
+}
+func benchmarkParallel(b *testing.B, l Limiter) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Allow()
+		}
+	})
+}
+func BenchmarkTokenBucket_Parallel(b *testing.B) {
+	benchmarkParallel(b, NewTokenBucketRate(1000, 1e6, &Metrics{}))
+}
+func BenchmarkAtomicTokenBucket_Parallel(b *testing.B) {
+	benchmarkParallel(b, NewAtomicTokenBucketRate(1000, 1e6, &Metrics{}))
+}
+func BenchmarkTokenBucket_ParallelNoMetrics(b *testing.B) {
+	benchmarkParallel(b, NewTokenBucketRate(1000, 1e6, nil))
+}
+func BenchmarkAtomicTokenBucket_ParallelNoMetrics(b *testing.B) {
+	benchmarkParallel(b, NewAtomicTokenBucketRate(1000, 1e6, nil))
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Backend interface {
+	Take(ctx context.Context, key string, cfg Config, n int) (Decision, error)
+}
+type DistributedLimiter struct {
+	backend Backend
+	key     string
+	cfg     Config
+	metrics *Metrics
+	clock   Clock
+	ctx     context.Context
+}
+
+func NewDistributedLimiter(backend Backend, key string, cfg Config, metrics *Metrics, opts ...Option) (*DistributedLimiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	o := buildOptions(opts)
+	return &DistributedLimiter{
+		backend: backend,
+		key:     key,
+		cfg:     cfg,
+		metrics: metrics,
+		clock:   o.clock,
+		ctx:     o.ctx,
+	}, nil
+}
+func (l *DistributedLimiter) Take(ctx context.Context, n int) (Decision, error) {
+	start := time.Now()
+	if n < 0 {
+		l.metrics.record(false, start)
+		return Decision{Limit: l.cfg.Limit, RetryAfter: InfDuration}, nil
+	}
+	d, err := l.backend.Take(ctx, l.key, l.cfg, n)
+	if err != nil {
+		return Decision{Limit: l.cfg.Limit, RetryAfter: pollInterval}, fmt.Errorf("ratelimit: backend take for %q: %w", l.key, err)
+	}
+	l.metrics.record(d.Allowed, start)
+	return d, nil
+}
+func (l *DistributedLimiter) Allow() bool {
+	return l.AllowN(1).Allowed
+}
+func (l *DistributedLimiter) AllowN(n int) Decision {
+	d, err := l.Take(l.ctx, n)
+	if err != nil {
+		l.metrics.record(false, time.Now())
+	}
+	return d
+}
+func (l *DistributedLimiter) Reserve(n int) *Reservation {
+	return reserve(l, l.clock, n)
+}
+func (l *DistributedLimiter) Wait(ctx context.Context, n int) error {
+	return wait(ctx, l.clock, l.metrics, l, n)
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type limiterBackend struct {
+	limiters map[string]Limiter
+	clock    Clock
+	err      error
+}
+
+func (b *limiterBackend) Take(ctx context.Context, key string, cfg Config, n int) (Decision, error) {
+	if b.err != nil {
+		return Decision{}, b.err
+	}
+	if b.limiters[key] == nil {
+		b.limiters[key], _ = New(cfg, nil, WithClock(b.clock))
+	}
+	return b.limiters[key].AllowN(n), nil
+}
+func TestDistributedLimiter(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	backend := &limiterBackend{limiters: make(map[string]Limiter), clock: clock}
+	metrics := &Metrics{}
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 1, Window: time.Second}
+	l, err := NewDistributedLimiter(backend, "a", cfg, metrics, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Allow() || l.Allow() {
+		t.Error("expected the backend decision to be returned")
+	}
+	done := make(chan error)
+	go func() { done <- l.Wait(context.Background(), 1) }()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("expected Wait to retry after the backend's delay, got %v", err)
+	}
+	backend.err = errors.New("connection refused")
+	if _, err := l.Take(context.Background(), 1); !errors.Is(err, backend.err) {
+		t.Errorf("expected the backend error to be wrapped, got %v", err)
+	}
+	if d := l.AllowN(1); d.Allowed {
+		t.Error("expected a failing backend to deny requests")
+	}
+	if allowed, rejected := metrics.Counts(); allowed != 2 || rejected != 3 {
+		t.Errorf("unexpected counts %d/%d", allowed, rejected)
+	}
+	if _, err := NewDistributedLimiter(backend, "a", Config{Algorithm: AlgorithmTokenBucket}, nil); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+type ManualClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*manualTimer
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	c := &ManualClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTimer{clock: c, deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+func (c *ManualClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+func (c *ManualClock) remove(t *manualTimer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+func (t *manualTimer) Stop() bool {
+	return t.clock.remove(t)
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualClock_Advance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	early := clock.After(time.Second)
+	late := clock.NewTimer(time.Minute)
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-early:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case now := <-early:
+		if !now.Equal(start.Add(time.Second)) {
+			t.Errorf("timer fired at %v, want %v", now, start.Add(time.Second))
+		}
+	default:
+		t.Fatal("expected timer to fire once its deadline passed")
+	}
+	if !late.Stop() {
+		t.Error("expected Stop to report the pending timer as stopped")
+	}
+	clock.Advance(time.Hour)
+	select {
+	case <-late.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+	if got := clock.Now(); !got.Equal(start.Add(time.Hour + time.Second)) {
+		t.Errorf("Now() = %v, want %v", got, start.Add(time.Hour+time.Second))
+	}
+}
@@ -0,0 +1,389 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+const (
+	defaultVirtualNodes = 64
+	defaultTTL          = 10 * time.Minute
+	defaultTimeout      = time.Second
+	takePath            = "/_ratelimit/take"
+	refundPath          = "/_ratelimit/refund"
+)
+
+type Stats struct {
+	Peers      int `json:"peers"`
+	Owned      int `json:"owned"`
+	Local      int `json:"local"`
+	Forwarded  int `json:"forwarded"`
+	Served     int `json:"served"`
+	Replicas   int `json:"replicas"`
+	Replicated int `json:"replicated"`
+	Refunded   int `json:"refunded"`
+	Handoffs   int `json:"handoffs"`
+}
+type keyID struct {
+	key string
+	cfg ratelimit.Config
+}
+type ownedKey struct {
+	limiter ratelimit.Limiter
+	last    time.Time
+}
+type replicaKey struct {
+	limiter *ratelimit.HybridLimiter
+	last    time.Time
+}
+type takeRequest struct {
+	Key    string
+	Config ratelimit.Config
+	N      int
+}
+type Node struct {
+	self      string
+	client    *http.Client
+	token     string
+	clock     ratelimit.Clock
+	vnodes    int
+	ttl       time.Duration
+	timeout   time.Duration
+	hotCalls  int
+	lease     int
+	overshoot int
+	mutex     sync.Mutex
+	ring      *Ring
+	owned     map[keyID]*ownedKey
+	swept     time.Time
+	calls     map[keyID]int
+	window    time.Time
+	replicas  map[keyID]*replicaKey
+	stats     Stats
+}
+type Option func(*Node)
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(node *Node) {
+		node.client = client
+	}
+}
+func WithToken(token string) Option {
+	return func(node *Node) {
+		node.token = token
+	}
+}
+func WithClock(clock ratelimit.Clock) Option {
+	return func(node *Node) {
+		node.clock = clock
+	}
+}
+func WithVirtualNodes(vnodes int) Option {
+	return func(node *Node) {
+		node.vnodes = vnodes
+	}
+}
+func WithTTL(ttl time.Duration) Option {
+	return func(node *Node) {
+		node.ttl = ttl
+	}
+}
+func WithTimeout(timeout time.Duration) Option {
+	return func(node *Node) {
+		node.timeout = timeout
+	}
+}
+func WithHotKeys(calls, lease, overshoot int) Option {
+	return func(node *Node) {
+		node.hotCalls, node.lease, node.overshoot = calls, lease, overshoot
+	}
+}
+func NewNode(self string, peers []string, opts ...Option) *Node {
+	node := &Node{
+		self:     self,
+		clock:    ratelimit.RealClock{},
+		vnodes:   defaultVirtualNodes,
+		ttl:      defaultTTL,
+		timeout:  defaultTimeout,
+		owned:    make(map[keyID]*ownedKey),
+		calls:    make(map[keyID]int),
+		replicas: make(map[keyID]*replicaKey),
+	}
+	for _, opt := range opts {
+		opt(node)
+	}
+	if node.client == nil {
+		node.client = &http.Client{Timeout: node.timeout}
+	}
+	node.ring = NewRing(node.vnodes, append([]string{self}, peers...)...)
+	node.swept, node.window = node.clock.Now(), node.clock.Now()
+	return node
+}
+func (node *Node) Take(ctx context.Context, key string, cfg ratelimit.Config, n int) (ratelimit.Decision, error) {
+	node.mutex.Lock()
+	owner := node.ring.Owner(key)
+	if owner == node.self {
+		node.stats.Local++
+		node.mutex.Unlock()
+		return node.takeLocal(key, cfg, n)
+	}
+	expired := node.sweep(node.clock.Now())
+	replica := node.replica(keyID{key: key, cfg: cfg})
+	node.mutex.Unlock()
+	closeReplicas(expired)
+	if replica != nil {
+		if d := replica.AllowN(n); d.Allowed || replica.Ready() {
+			return d, nil
+		}
+	}
+	return node.forward(ctx, owner, takeRequest{Key: key, Config: cfg, N: n})
+}
+func (node *Node) takeLocal(key string, cfg ratelimit.Config, n int) (ratelimit.Decision, error) {
+	id := keyID{key: key, cfg: cfg}
+	node.mutex.Lock()
+	now := node.clock.Now()
+	expired := node.sweep(now)
+	entry := node.owned[id]
+	if entry == nil {
+		l, err := ratelimit.New(cfg, nil, ratelimit.WithClock(node.clock))
+		if err != nil {
+			node.mutex.Unlock()
+			closeReplicas(expired)
+			return ratelimit.Decision{}, err
+		}
+		entry = &ownedKey{limiter: l}
+		node.owned[id] = entry
+	}
+	entry.last = now
+	node.mutex.Unlock()
+	closeReplicas(expired)
+	return entry.limiter.AllowN(n), nil
+}
+func (node *Node) refundLocal(key string, cfg ratelimit.Config, n int) {
+	node.mutex.Lock()
+	entry := node.owned[keyID{key: key, cfg: cfg}]
+	node.mutex.Unlock()
+	if entry != nil && ratelimit.Credit(entry.limiter, n) {
+		node.mutex.Lock()
+		node.stats.Refunded += n
+		node.mutex.Unlock()
+	}
+}
+func (node *Node) sweep(now time.Time) []*ratelimit.HybridLimiter {
+	if now.Sub(node.swept) < node.ttl {
+		return nil
+	}
+	node.swept = now
+	for id, entry := range node.owned {
+		if now.Sub(entry.last) >= node.ttl && consumed(entry.limiter) == 0 {
+			delete(node.owned, id)
+		}
+	}
+	var expired []*ratelimit.HybridLimiter
+	for id, entry := range node.replicas {
+		if now.Sub(entry.last) >= node.ttl {
+			expired = append(expired, entry.limiter)
+			delete(node.replicas, id)
+		}
+	}
+	return expired
+}
+func (node *Node) replica(id keyID) *ratelimit.HybridLimiter {
+	if node.hotCalls <= 0 {
+		return nil
+	}
+	now := node.clock.Now()
+	if entry := node.replicas[id]; entry != nil {
+		entry.last = now
+		return entry.limiter
+	}
+	if now.Sub(node.window) >= time.Second {
+		clear(node.calls)
+		node.window = now
+	}
+	node.calls[id]++
+	if node.calls[id] < node.hotCalls {
+		return nil
+	}
+	delete(node.calls, id)
+	replica, err := ratelimit.NewHybridLimiter(remote{node}, id.key, id.cfg, node.lease, node.overshoot, nil, ratelimit.WithClock(node.clock), ratelimit.WithTimeout(node.timeout))
+	if err != nil {
+		return nil
+	}
+	node.replicas[id] = &replicaKey{limiter: replica, last: now}
+	node.stats.Replicated++
+	return replica
+}
+func (node *Node) forward(ctx context.Context, peer string, req takeRequest) (ratelimit.Decision, error) {
+	var d ratelimit.Decision
+	if err := node.post(ctx, peer+takePath, req, &d); err != nil {
+		return d, err
+	}
+	node.mutex.Lock()
+	node.stats.Forwarded++
+	node.mutex.Unlock()
+	return d, nil
+}
+func (node *Node) post(ctx context.Context, url string, req takeRequest, reply any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+node.token)
+	resp, err := node.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("cluster: %s returned %s", url, resp.Status)
+	}
+	if reply == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+func (node *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != takePath && r.URL.Path != refundPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !node.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ratelimit-cluster"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req takeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.N <= 0 || req.N > req.Config.Limit {
+		http.Error(w, "N must be between 1 and the configured limit", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == refundPath {
+		node.refundLocal(req.Key, req.Config, req.N)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	d, err := node.takeLocal(req.Key, req.Config, req.N)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	node.mutex.Lock()
+	node.stats.Served++
+	node.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+func (node *Node) authorized(r *http.Request) bool {
+	scheme, credentials, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if node.token == "" || !found || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), []byte(node.token)) == 1
+}
+func (node *Node) SetPeers(ctx context.Context, peers ...string) error {
+	errs := []error{node.Close()}
+	node.mutex.Lock()
+	clear(node.calls)
+	node.ring = NewRing(node.vnodes, append([]string{node.self}, peers...)...)
+	moved := make(map[keyID]ratelimit.Limiter)
+	for id, entry := range node.owned {
+		if node.ring.Owner(id.key) != node.self {
+			moved[id] = entry.limiter
+			delete(node.owned, id)
+		}
+	}
+	node.mutex.Unlock()
+	for id, l := range moved {
+		used := min(consumed(l), id.cfg.Limit)
+		if used == 0 {
+			continue
+		}
+		if _, err := node.forward(ctx, node.Owner(id.key), takeRequest{Key: id.key, Config: id.cfg, N: used}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		node.mutex.Lock()
+		node.stats.Handoffs++
+		node.mutex.Unlock()
+	}
+	return errors.Join(errs...)
+}
+func consumed(l ratelimit.Limiter) int {
+	state, ok := ratelimit.Inspect(l)
+	if !ok {
+		return 0
+	}
+	if state.Gauge == "tokens" {
+		return max(state.Limit-int(math.Floor(state.Level)), 0)
+	}
+	return int(math.Ceil(state.Level))
+}
+func (node *Node) Owner(key string) string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.ring.Owner(key)
+}
+func (node *Node) Close() error {
+	node.mutex.Lock()
+	replicas := node.replicas
+	node.replicas = make(map[keyID]*replicaKey)
+	node.mutex.Unlock()
+	var errs []error
+	for _, entry := range replicas {
+		errs = append(errs, entry.limiter.Close())
+	}
+	return errors.Join(errs...)
+}
+func closeReplicas(replicas []*ratelimit.HybridLimiter) {
+	for _, replica := range replicas {
+		replica.Close()
+	}
+}
+func (node *Node) Stats() Stats {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	stats := node.stats
+	stats.Peers = len(node.ring.peers)
+	stats.Owned = len(node.owned)
+	stats.Replicas = len(node.replicas)
+	return stats
+}
+
+type remote struct {
+	node *Node
+}
+
+func (r remote) Take(ctx context.Context, key string, cfg ratelimit.Config, n int) (ratelimit.Decision, error) {
+	return r.node.forward(ctx, r.node.Owner(key), takeRequest{Key: key, Config: cfg, N: n})
+}
+func (r remote) Refund(ctx context.Context, key string, cfg ratelimit.Config, n int) error {
+	return r.node.post(ctx, r.node.Owner(key)+refundPath, takeRequest{Key: key, Config: cfg, N: n}, nil)
+}
@@ -0,0 +1,260 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+func newTestCluster(t *testing.T, count int, opts ...Option) ([]*Node, []*httptest.Server) {
+	nodes := make([]*Node, count)
+	servers := make([]*httptest.Server, count)
+	urls := make([]string, count)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nodes[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		urls[i] = servers[i].URL
+	}
+	opts = append([]Option{WithToken("secret")}, opts...)
+	for i := range nodes {
+		node := NewNode(urls[i], urls, opts...)
+		t.Cleanup(func() { node.Close() })
+		nodes[i] = node
+	}
+	return nodes, servers
+}
+func keyOwnedBy(t *testing.T, node *Node, owner string) string {
+	for i := 0; i < 10000; i++ {
+		if key := fmt.Sprintf("client-%d", i); node.Owner(key) == owner {
+			return key
+		}
+	}
+	t.Fatalf("expected some key to be owned by %s", owner)
+	return ""
+}
+func syncReplicas(node *Node) {
+	var replicas []*ratelimit.HybridLimiter
+	node.mutex.Lock()
+	for _, entry := range node.replicas {
+		replicas = append(replicas, entry.limiter)
+	}
+	node.mutex.Unlock()
+	for _, replica := range replicas {
+		replica.Sync()
+	}
+}
+func TestNode_Forwarding(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	nodes, servers := newTestCluster(t, 3, WithClock(clock))
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 5, Window: time.Minute}
+	key := keyOwnedBy(t, nodes[0], servers[1].URL)
+	allowed := 0
+	for i := 0; i < 9; i++ {
+		d, err := nodes[i%3].Take(context.Background(), key, cfg, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("expected the nodes to share one budget, got %d allowed", allowed)
+	}
+	if stats := nodes[1].Stats(); stats.Local != 3 || stats.Served != 6 || stats.Owned != 1 || stats.Peers != 3 {
+		t.Errorf("expected the owner to serve its peers, got %+v", stats)
+	}
+	if stats := nodes[0].Stats(); stats.Forwarded != 3 || stats.Owned != 0 {
+		t.Errorf("expected a non-owner to forward, got %+v", stats)
+	}
+	limiter, _ := ratelimit.NewDistributedLimiter(nodes[2], key, cfg, nil, ratelimit.WithClock(clock))
+	clock.Advance(time.Minute)
+	if !limiter.Allow() {
+		t.Error("expected a distributed limiter to use the cluster as its backend")
+	}
+	servers[1].Close()
+	if _, err := nodes[0].Take(context.Background(), key, cfg, 1); err == nil {
+		t.Error("expected an unreachable owner to fail")
+	}
+}
+func TestNode_HotKeys(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	nodes, servers := newTestCluster(t, 2, WithClock(clock), WithHotKeys(5, 10, 0))
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 100, Window: time.Minute}
+	key := keyOwnedBy(t, nodes[0], servers[1].URL)
+	allowed := 0
+	for i := 0; i < 200; i++ {
+		d, err := nodes[0].Take(context.Background(), key, cfg, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed {
+			allowed++
+		}
+	}
+	served := nodes[1].Stats().Served
+	t.Logf("allowed %d of 200 requests with %d calls to the owner", allowed, served)
+	if allowed > 100 || allowed < 90 {
+		t.Errorf("expected the replica to respect the owner's budget, got %d allowed", allowed)
+	}
+	if stats := nodes[0].Stats(); stats.Replicated != 1 || served > 50 {
+		t.Errorf("expected a hot key to be replicated to save round trips, got %+v and %d calls", stats, served)
+	}
+}
+func TestNode_Replicas(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	nodes, servers := newTestCluster(t, 2, WithClock(clock), WithHotKeys(2, 10, 0), WithTTL(time.Minute))
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 100, Window: time.Hour}
+	key := keyOwnedBy(t, nodes[0], servers[1].URL)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		nodes[0].Take(ctx, key, cfg, 1)
+	}
+	if stats := nodes[0].Stats(); stats.Replicas != 1 {
+		t.Fatalf("expected a replica for the hot key, got %+v", stats)
+	}
+	syncReplicas(nodes[0])
+	nodes[0].Take(ctx, key, cfg, 1)
+	clock.Advance(30 * time.Second)
+	nodes[1].Take(ctx, key, cfg, 1)
+	clock.Advance(30 * time.Second)
+	nodes[0].Take(ctx, key, cfg, 1)
+	if stats := nodes[0].Stats(); stats.Replicas != 0 {
+		t.Errorf("expected an idle replica to be evicted, got %+v", stats)
+	}
+	if stats := nodes[1].Stats(); stats.Refunded != 9 {
+		t.Errorf("expected the unused lease to be refunded to the owner, got %+v", stats)
+	}
+	if d, _ := nodes[1].Take(ctx, key, cfg, 95); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected only the tokens actually used to count against the owner, got %+v", d)
+	}
+}
+func TestNode_Sweep(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	node := NewNode("http://self", nil, WithClock(clock), WithTTL(time.Minute))
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 2, Window: time.Hour}
+	ctx := context.Background()
+	node.Take(ctx, "a", cfg, 2)
+	clock.Advance(2 * time.Minute)
+	node.Take(ctx, "b", cfg, 1)
+	if d, _ := node.Take(ctx, "a", cfg, 1); d.Allowed || node.Stats().Owned != 2 {
+		t.Errorf("expected an idle key with a live window to survive the sweep, got %+v", d)
+	}
+	clock.Advance(time.Hour)
+	node.Take(ctx, "b", cfg, 1)
+	if stats := node.Stats(); stats.Owned != 1 {
+		t.Errorf("expected the key to be evicted once its window reset, got %+v", stats)
+	}
+}
+func TestNode_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	node := NewNode("http://self", []string{hung.URL}, WithToken("secret"), WithTimeout(50*time.Millisecond), WithHotKeys(2, 10, 0))
+	defer node.Close()
+	key := keyOwnedBy(t, node, hung.URL)
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 100, Window: time.Minute}
+	start := time.Now()
+	if _, err := node.Take(context.Background(), key, cfg, 1); err == nil {
+		t.Error("expected a forwarded request to a hung owner to time out")
+	}
+	if _, err := node.Take(context.Background(), key, cfg, 1); err == nil {
+		t.Error("expected a request forwarded while the lease is fetched to time out")
+	}
+	syncReplicas(node)
+	if d, _ := node.Take(context.Background(), key, cfg, 1); d.Allowed || d.RetryAfter <= 0 {
+		t.Errorf("expected a lease from a hung owner to time out, got %+v", d)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected requests to a hung owner to give up quickly, took %v", elapsed)
+	}
+}
+func TestNode_Rebalance(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	nodes, servers := newTestCluster(t, 3, WithClock(clock))
+	urls := []string{servers[0].URL, servers[1].URL, servers[2].URL}
+	ctx := context.Background()
+	for _, i := range []int{0, 1} {
+		if err := nodes[i].SetPeers(ctx, urls[0], urls[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var key string
+	for i := 0; key == ""; i++ {
+		candidate := fmt.Sprintf("client-%d", i)
+		if nodes[0].Owner(candidate) == urls[0] && nodes[2].Owner(candidate) == urls[2] {
+			key = candidate
+		}
+	}
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 5, Rate: time.Hour}
+	for i := 0; i < 3; i++ {
+		nodes[1].Take(ctx, key, cfg, 1)
+	}
+	for _, i := range []int{0, 1} {
+		if err := nodes[i].SetPeers(ctx, urls...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := nodes[0].Stats(); stats.Handoffs != 1 || stats.Owned != 0 {
+		t.Errorf("expected the moved key to be handed off, got %+v", stats)
+	}
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if d, err := nodes[1].Take(ctx, key, cfg, 1); err == nil && d.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("expected the new owner to keep the consumed budget, got %d allowed", allowed)
+	}
+}
+func TestNode_ServeHTTP(t *testing.T) {
+	node := NewNode("http://self", nil, WithToken("secret"))
+	window := `"Config":{"Algorithm":"fixed-window-counter","Limit":2,"Window":1000000000}`
+	for _, tt := range []struct {
+		method, path, token, body string
+		status                    int
+	}{
+		{http.MethodGet, takePath, "secret", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/other", "secret", "", http.StatusNotFound},
+		{http.MethodPost, takePath, "", `{"Key":"a",` + window + `,"N":1}`, http.StatusUnauthorized},
+		{http.MethodPost, takePath, "guess", `{"Key":"a",` + window + `,"N":1}`, http.StatusUnauthorized},
+		{http.MethodPost, takePath, "secret", `{"Key":"a","Bogus":1}`, http.StatusBadRequest},
+		{http.MethodPost, takePath, "secret", `{"Key":"a","Config":{"Algorithm":"nope","Limit":1},"N":1}`, http.StatusBadRequest},
+		{http.MethodPost, takePath, "secret", `{"Key":"a",` + window + `,"N":-1000}`, http.StatusBadRequest},
+		{http.MethodPost, takePath, "secret", `{"Key":"a",` + window + `,"N":0}`, http.StatusBadRequest},
+		{http.MethodPost, takePath, "secret", `{"Key":"a",` + window + `,"N":3}`, http.StatusBadRequest},
+		{http.MethodPost, refundPath, "secret", `{"Key":"a",` + window + `,"N":-1000}`, http.StatusBadRequest},
+		{http.MethodPost, takePath, "secret", `{"Key":"a",` + window + `,"N":2}`, http.StatusOK},
+		{http.MethodPost, refundPath, "secret", `{"Key":"a",` + window + `,"N":1}`, http.StatusNoContent},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		node.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("expected %s %s %s to return %d, got %d", tt.method, tt.path, tt.body, tt.status, rec.Code)
+		}
+	}
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 2, Window: time.Second}
+	if d, _ := node.Take(context.Background(), "a", cfg, 2); d.Allowed || d.Remaining != 1 {
+		t.Errorf("expected rejected requests to leave the budget alone, got %+v", d)
+	}
+	if open := NewNode("http://open", nil); open.authorized(httptest.NewRequest(http.MethodPost, takePath, nil)) {
+		t.Error("expected a node without a token to refuse every peer")
+	}
+}
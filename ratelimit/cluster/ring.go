@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+type Ring struct {
+	peers  []string
+	hashes []uint32
+	owners map[uint32]string
+}
+
+func NewRing(vnodes int, peers ...string) *Ring {
+	r := &Ring{owners: make(map[uint32]string)}
+	for _, peer := range peers {
+		if slices.Contains(r.peers, peer) {
+			continue
+		}
+		r.peers = append(r.peers, peer)
+		for i := 0; i < max(vnodes, 1); i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = peer
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	slices.Sort(r.hashes)
+	return r
+}
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+func (r *Ring) Peers() []string {
+	return slices.Clone(r.peers)
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	if owner := NewRing(8).Owner("a"); owner != "" {
+		t.Errorf("expected an empty ring to own nothing, got %q", owner)
+	}
+	peers := []string{"http://a", "http://b", "http://c"}
+	ring := NewRing(64, append(peers, "http://a")...)
+	if len(ring.Peers()) != 3 {
+		t.Errorf("expected duplicate peers to be ignored, got %v", ring.Peers())
+	}
+	owned := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := ring.Owner(key)
+		if owner != NewRing(64, peers[2], peers[0], peers[1]).Owner(key) {
+			t.Fatalf("expected ownership of %q to be independent of peer order", key)
+		}
+		owned[owner]++
+	}
+	for _, peer := range peers {
+		if owned[peer] < 500 {
+			t.Errorf("expected keys to spread across peers, got %v", owned)
+		}
+	}
+	grown := NewRing(64, append(peers, "http://d")...)
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if before, after := ring.Owner(key), grown.Owner(key); before != after {
+			if after != "http://d" {
+				t.Fatalf("expected %q to move only to the new peer, got %s -> %s", key, before, after)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Errorf("expected a minority of keys to move on membership change, got %d", moved)
+	}
+}
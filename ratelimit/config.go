@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	AlgorithmTokenBucket          Algorithm = "token-bucket"
+	AlgorithmLeakyBucket          Algorithm = "leaky-bucket"
+	AlgorithmFixedWindowCounter   Algorithm = "fixed-window-counter"
+	AlgorithmSlidingWindowLog     Algorithm = "sliding-window-log"
+	AlgorithmSlidingWindowCounter Algorithm = "sliding-window-counter"
+	AlgorithmGCRA                 Algorithm = "gcra"
+)
+const defaultBuckets = 60
+
+type reconfigurer interface {
+	reconfigure(cfg Config) bool
+}
+type Config struct {
+	Algorithm Algorithm
+	Limit     int
+	Rate      time.Duration
+	PerSecond float64
+	Window    time.Duration
+	Buckets   int
+}
+
+func (c Config) validate() error {
+	if c.Limit <= 0 {
+		return fmt.Errorf("ratelimit: limit must be positive, got %d", c.Limit)
+	}
+	switch c.Algorithm {
+	case AlgorithmTokenBucket, AlgorithmLeakyBucket, AlgorithmGCRA:
+		if c.Rate <= 0 && c.PerSecond <= 0 {
+			return fmt.Errorf("ratelimit: %s requires a positive rate", c.Algorithm)
+		}
+	case AlgorithmFixedWindowCounter, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter:
+		if c.Window <= 0 {
+			return fmt.Errorf("ratelimit: %s requires a positive window", c.Algorithm)
+		}
+	default:
+		return fmt.Errorf("ratelimit: unknown algorithm %q", c.Algorithm)
+	}
+	return nil
+}
+func (c Config) TokenRate() Rate {
+	if c.PerSecond > 0 {
+		return Rate(c.PerSecond)
+	}
+	return Every(c.Rate)
+}
+func New(cfg Config, metrics *Metrics, opts ...Option) (Limiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Algorithm {
+	case AlgorithmTokenBucket:
+		return NewTokenBucketRate(cfg.Limit, cfg.TokenRate(), metrics, opts...), nil
+	case AlgorithmGCRA:
+		return NewAtomicTokenBucketRate(cfg.Limit, cfg.TokenRate(), metrics, opts...), nil
+	case AlgorithmLeakyBucket:
+		return NewLeakyBucketRate(cfg.Limit, cfg.TokenRate(), metrics, opts...), nil
+	case AlgorithmFixedWindowCounter:
+		fw, err := NewFixedWindowCounter(cfg.Limit, cfg.Window, metrics, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return fw, nil
+	case AlgorithmSlidingWindowLog:
+		return NewSlidingWindowLog(cfg.Limit, cfg.Window, metrics, opts...), nil
+	}
+	return NewSlidingWindowCounter(cfg.Limit, cfg.Window, cfg.buckets(), metrics, opts...), nil
+}
+func (c Config) buckets() int {
+	if c.Buckets <= 0 {
+		return defaultBuckets
+	}
+	return c.Buckets
+}
+func Reconfigure(l Limiter, cfg Config, metrics *Metrics, opts ...Option) (Limiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if r, ok := l.(reconfigurer); ok && r.reconfigure(cfg) {
+		return l, nil
+	}
+	return New(cfg, metrics, opts...)
+}
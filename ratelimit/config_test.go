@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want Limiter
+	}{
+		{Config{Algorithm: AlgorithmTokenBucket, Limit: 10, Rate: time.Second}, &TokenBucket{}},
+		{Config{Algorithm: AlgorithmGCRA, Limit: 10, PerSecond: 100}, &AtomicTokenBucket{}},
+		{Config{Algorithm: AlgorithmLeakyBucket, Limit: 10, Rate: time.Second}, &LeakyBucket{}},
+		{Config{Algorithm: AlgorithmLeakyBucket, Limit: 10, PerSecond: 0.5}, &LeakyBucket{}},
+		{Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 10, Window: time.Minute}, &FixedWindowCounter{}},
+		{Config{Algorithm: AlgorithmSlidingWindowLog, Limit: 10, Window: time.Minute}, &SlidingWindowLog{}},
+		{Config{Algorithm: AlgorithmSlidingWindowCounter, Limit: 10, Window: time.Minute}, &SlidingWindowCounter{}},
+	}
+	for _, tt := range tests {
+		l, err := New(tt.cfg, nil)
+		if err != nil {
+			t.Fatalf("New(%s) returned error: %v", tt.cfg.Algorithm, err)
+		}
+		if got, want := typeName(l), typeName(tt.want); got != want {
+			t.Errorf("New(%s) returned %s, want %s", tt.cfg.Algorithm, got, want)
+		}
+		if !l.Allow() {
+			t.Errorf("expected a fresh %s to allow a request", tt.cfg.Algorithm)
+		}
+	}
+}
+func TestNew_Invalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Algorithm: AlgorithmTokenBucket, Limit: 0, Rate: time.Second},
+		{Algorithm: AlgorithmLeakyBucket, Limit: 1},
+		{Algorithm: AlgorithmFixedWindowCounter, Limit: 1},
+		{Algorithm: "generic-cell-rate", Limit: 1, Rate: time.Second},
+	} {
+		if _, err := New(cfg, nil); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+func typeName(l Limiter) string {
+	return fmt.Sprintf("%T", l)
+}
+func TestReconfigure(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(4, time.Second, nil, WithClock(clock))
+	bucket.AllowN(2)
+	l, err := Reconfigure(bucket, Config{Algorithm: AlgorithmTokenBucket, Limit: 8, Rate: time.Second}, nil, WithClock(clock))
+	if err != nil || l != bucket {
+		t.Fatalf("expected the bucket to be reconfigured in place, got %T, %v", l, err)
+	}
+	if d := l.AllowN(4); !d.Allowed || d.Remaining != 0 || d.Limit != 8 {
+		t.Errorf("expected the remaining tokens to be rescaled to the new burst, got %+v", d)
+	}
+	gcra := NewAtomicTokenBucket(2, time.Second, nil, WithClock(clock))
+	gcra.AllowN(2)
+	l, _ = Reconfigure(gcra, Config{Algorithm: AlgorithmGCRA, Limit: 2, Rate: time.Second}, nil, WithClock(clock))
+	if l != gcra || l.Allow() {
+		t.Error("expected reloading an identical gcra config to keep the spent burst")
+	}
+	window, _ := NewFixedWindowCounter(2, time.Hour, nil, WithClock(clock))
+	window.AllowN(2)
+	l, _ = Reconfigure(window, Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 3, Window: time.Minute}, nil, WithClock(clock))
+	if !l.Allow() || l.Allow() {
+		t.Error("expected the window count to carry over to the new limit")
+	}
+	if d := l.AllowN(1); d.ResetAt != clock.Now().Add(time.Minute) {
+		t.Errorf("expected the current window to end within the new duration, got %v", d.ResetAt.Sub(clock.Now()))
+	}
+	counter := NewSlidingWindowCounter(2, time.Minute, 6, nil, WithClock(clock))
+	counter.AllowN(2)
+	if l, _ = Reconfigure(counter, Config{Algorithm: AlgorithmSlidingWindowCounter, Limit: 3, Window: time.Minute, Buckets: 6}, nil); l != counter {
+		t.Error("expected a limit change to keep the sliding window counter")
+	}
+	if l, _ = Reconfigure(counter, Config{Algorithm: AlgorithmSlidingWindowCounter, Limit: 3, Window: time.Hour}, nil); l != counter {
+		t.Error("expected a window change to rebucket the sliding window counter in place")
+	}
+	if d := counter.AllowN(2); d.Allowed || d.Remaining != 1 {
+		t.Errorf("expected the counts to carry over to the new window, got %+v", d)
+	}
+	if l, _ = Reconfigure(bucket, Config{Algorithm: AlgorithmLeakyBucket, Limit: 3, Rate: time.Second}, nil); typeName(l) != "*ratelimit.LeakyBucket" {
+		t.Errorf("expected an algorithm change to build a new limiter, got %T", l)
+	}
+	if _, err := Reconfigure(bucket, Config{Algorithm: AlgorithmTokenBucket}, nil); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+}
@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Source struct {
+	Name    string
+	Route   string
+	Limiter Limiter
+	Metrics *Metrics
+	Keyed   *KeyedLimiter
+	Backend *ResilientBackend
+}
+type gauge interface {
+	level() (name string, value float64)
+}
+type exposition int
+
+const (
+	plainFormat exposition = iota
+	prometheusFormat
+	openMetricsFormat
+	jsonFormat
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var gaugeHelp = []struct{ name, help string }{
+	{"ratelimit_tokens", "Tokens currently available in the bucket."},
+	{"ratelimit_water_level", "Requests currently queued in the leaky bucket."},
+	{"ratelimit_window_fill", "Fraction of the window limit currently used."},
+}
+
+func ExpositionHandler(sources ...Source) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveMetrics(w, r, sources)
+	}
+}
+func serveMetrics(w http.ResponseWriter, r *http.Request, sources []Source) {
+	switch format := negotiate(r); format {
+	case openMetricsFormat:
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		writeExposition(w, format, sources)
+	case prometheusFormat:
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeExposition(w, format, sources)
+	case jsonFormat:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot(sources))
+	default:
+		writePlain(w, sources)
+	}
+}
+func negotiate(r *http.Request) exposition {
+	accept := r.Header.Get("Accept")
+	switch format := r.URL.Query().Get("format"); {
+	case format == "openmetrics" || strings.Contains(accept, "application/openmetrics-text"):
+		return openMetricsFormat
+	case format == "prometheus" || strings.Contains(accept, "version=0.0.4"):
+		return prometheusFormat
+	case format == "json" || strings.Contains(accept, "application/json"):
+		return jsonFormat
+	}
+	return plainFormat
+}
+func writeExposition(w io.Writer, format exposition, sources []Source) {
+	writeCounterHeader(w, format, "ratelimit_requests_total", "Requests evaluated by the limiter.")
+	for _, source := range sources {
+		if m := source.Metrics; m != nil {
+			allowed, rejected := m.Counts()
+			fmt.Fprintf(w, "ratelimit_requests_total%s %d\n", labels(source, "result", "allowed"), allowed)
+			fmt.Fprintf(w, "ratelimit_requests_total%s %d\n", labels(source, "result", "rejected"), rejected)
+		}
+	}
+	writeHeader(w, "ratelimit_allow_duration_seconds", "histogram", "Time spent deciding whether to admit a request.")
+	for _, source := range sources {
+		if m := source.Metrics; m != nil {
+			writeHistogram(w, "ratelimit_allow_duration_seconds", source, &latencyBuckets, &m.latency)
+		}
+	}
+	writeHeader(w, "ratelimit_wait_duration_seconds", "histogram", "Time callers were delayed by Wait.")
+	for _, source := range sources {
+		if m := source.Metrics; m != nil {
+			writeHistogram(w, "ratelimit_wait_duration_seconds", source, &waitBuckets, &m.waits)
+		}
+	}
+	levels := make(map[string][]string)
+	for _, source := range sources {
+		if g, ok := source.Limiter.(gauge); ok {
+			name, value := g.level()
+			levels[name] = append(levels[name], fmt.Sprintf("%s%s %s\n", name, labels(source), formatFloat(value)))
+		}
+	}
+	for _, family := range gaugeHelp {
+		if samples := levels[family.name]; len(samples) > 0 {
+			writeHeader(w, family.name, "gauge", family.help)
+			io.WriteString(w, strings.Join(samples, ""))
+		}
+	}
+	writeKeyed(w, format, sources)
+	writeBackends(w, format, sources)
+	if format == openMetricsFormat {
+		io.WriteString(w, "# EOF\n")
+	}
+}
+func writeKeyed(w io.Writer, format exposition, sources []Source) {
+	var keyed []Source
+	var stats []KeyedStats
+	for _, source := range sources {
+		if source.Keyed != nil {
+			keyed = append(keyed, source)
+			stats = append(stats, source.Keyed.Stats())
+		}
+	}
+	if len(keyed) == 0 {
+		return
+	}
+	writeHeader(w, "ratelimit_tracked_keys", "gauge", "Keys currently tracked by the keyed limiter.")
+	for i, source := range keyed {
+		fmt.Fprintf(w, "ratelimit_tracked_keys%s %d\n", labels(source), stats[i].Size)
+	}
+	writeCounterHeader(w, format, "ratelimit_key_evictions_total", "Keys evicted for being idle or to make room for new keys.")
+	for i, source := range keyed {
+		fmt.Fprintf(w, "ratelimit_key_evictions_total%s %d\n", labels(source, "reason", "idle"), stats[i].Evictions)
+		fmt.Fprintf(w, "ratelimit_key_evictions_total%s %d\n", labels(source, "reason", "capacity"), stats[i].Displaced)
+	}
+	writeCounterHeader(w, format, "ratelimit_key_overflows_total", "Requests for new keys sent to the shared overflow limiter.")
+	for i, source := range keyed {
+		fmt.Fprintf(w, "ratelimit_key_overflows_total%s %d\n", labels(source), stats[i].Overflows)
+	}
+	writeCounterHeader(w, format, "ratelimit_key_refusals_total", "Requests for new keys refused because the table was full.")
+	for i, source := range keyed {
+		fmt.Fprintf(w, "ratelimit_key_refusals_total%s %d\n", labels(source), stats[i].Refusals)
+	}
+}
+func writeBackends(w io.Writer, format exposition, sources []Source) {
+	var backed []Source
+	var stats []BackendStats
+	for _, source := range sources {
+		if source.Backend != nil {
+			backed = append(backed, source)
+			stats = append(stats, source.Backend.Stats())
+		}
+	}
+	if len(backed) == 0 {
+		return
+	}
+	writeCounterHeader(w, format, "ratelimit_backend_calls_total", "Calls made to the shared state backend.")
+	for i, source := range backed {
+		fmt.Fprintf(w, "ratelimit_backend_calls_total%s %d\n", labels(source), stats[i].Calls)
+	}
+	writeCounterHeader(w, format, "ratelimit_backend_failures_total", "Backend calls that failed or timed out.")
+	for i, source := range backed {
+		fmt.Fprintf(w, "ratelimit_backend_failures_total%s %d\n", labels(source), stats[i].Failures)
+	}
+	writeCounterHeader(w, format, "ratelimit_backend_fallbacks_total", "Decisions made by the failure mode instead of the backend.")
+	for i, source := range backed {
+		fmt.Fprintf(w, "ratelimit_backend_fallbacks_total%s %d\n", labels(source, "mode", stats[i].Mode), stats[i].Fallbacks)
+	}
+	writeCounterHeader(w, format, "ratelimit_backend_circuit_trips_total", "Times the circuit breaker opened after repeated failures.")
+	for i, source := range backed {
+		fmt.Fprintf(w, "ratelimit_backend_circuit_trips_total%s %d\n", labels(source), stats[i].Trips)
+	}
+	writeHeader(w, "ratelimit_backend_circuit_open", "gauge", "Whether the circuit breaker is currently open.")
+	for i, source := range backed {
+		open := 0
+		if stats[i].Open {
+			open = 1
+		}
+		fmt.Fprintf(w, "ratelimit_backend_circuit_open%s %d\n", labels(source), open)
+	}
+}
+func writeCounterHeader(w io.Writer, format exposition, name, help string) {
+	if format == openMetricsFormat {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	writeHeader(w, name, "counter", help)
+}
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+func writeHistogram(w io.Writer, name string, source Source, bounds *[histogramBuckets]float64, h *histogram) {
+	for i, bound := range bounds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(source, "le", formatFloat(bound)), h.counts[i].Load())
+	}
+	count := h.count.Load()
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(source, "le", "+Inf"), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(source), formatFloat(h.total()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels(source), count)
+}
+func labels(source Source, pairs ...string) string {
+	pairs = append([]string{"limiter", source.Name, "route", source.Route}, pairs...)
+	var parts []string
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpositionHandler_Negotiation(t *testing.T) {
+	metrics := &Metrics{}
+	bucket := NewTokenBucket(2, time.Minute, metrics, WithClock(NewManualClock(time.Now())))
+	for i := 0; i < 3; i++ {
+		bucket.Allow()
+	}
+	handler := ExpositionHandler(Source{Name: "global", Route: "/", Limiter: bucket, Metrics: metrics})
+	tests := []struct {
+		accept      string
+		contentType string
+		want        []string
+	}{
+		{"", "text/plain; charset=utf-8", []string{"Total requests: 2\nRejected requests: 1\n"}},
+		{"text/plain;version=0.0.4;q=0.5,*/*;q=0.1", "text/plain; version=0.0.4; charset=utf-8", []string{
+			"# TYPE ratelimit_requests_total counter\n",
+			`ratelimit_requests_total{limiter="global",route="/",result="allowed"} 2` + "\n",
+			`ratelimit_requests_total{limiter="global",route="/",result="rejected"} 1` + "\n",
+			`ratelimit_allow_duration_seconds_bucket{limiter="global",route="/",le="+Inf"} 3` + "\n",
+			`ratelimit_allow_duration_seconds_count{limiter="global",route="/"} 3` + "\n",
+			"# TYPE ratelimit_tokens gauge\n",
+			`ratelimit_tokens{limiter="global",route="/"} 0` + "\n",
+		}},
+		{"application/openmetrics-text;version=1.0.0", "application/openmetrics-text; version=1.0.0; charset=utf-8", []string{
+			"# TYPE ratelimit_requests counter\n",
+			`ratelimit_requests_total{limiter="global",route="/",result="allowed"} 2` + "\n",
+			"# EOF\n",
+		}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("Accept %q: expected Content-Type %q, got %q", tt.accept, tt.contentType, got)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("Accept %q: expected body to contain %q, got:\n%s", tt.accept, want, rr.Body.String())
+			}
+		}
+	}
+}
+func TestExpositionHandler_Gauges(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	leaky := NewLeakyBucket(4, time.Second, nil, WithClock(clock))
+	window, _ := NewFixedWindowCounter(4, time.Minute, nil, WithClock(clock))
+	log := NewSlidingWindowLog(4, time.Minute, nil, WithClock(clock))
+	counter := NewSlidingWindowCounter(4, time.Minute, 4, nil, WithClock(clock))
+	for _, l := range []Limiter{leaky, window, log, counter} {
+		l.AllowN(3)
+	}
+	clock.Advance(time.Second)
+	handler := ExpositionHandler(
+		Source{Name: "leaky", Limiter: leaky},
+		Source{Name: "fixed", Limiter: window},
+		Source{Name: "log", Limiter: log},
+		Source{Name: "counter", Limiter: counter},
+	)
+	req := httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	for _, want := range []string{
+		`ratelimit_water_level{limiter="leaky"} 2` + "\n",
+		`ratelimit_window_fill{limiter="fixed"} 0.75` + "\n",
+		`ratelimit_window_fill{limiter="log"} 0.75` + "\n",
+		`ratelimit_window_fill{limiter="counter"} 0.75` + "\n",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected body to contain %q, got:\n%s", want, rr.Body.String())
+		}
+	}
+	if strings.Count(rr.Body.String(), "# TYPE ratelimit_window_fill gauge") != 1 {
+		t.Errorf("expected a single window fill family, got:\n%s", rr.Body.String())
+	}
+}
+func TestMetrics_WaitHistogram(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	metrics := &Metrics{}
+	bucket := NewTokenBucket(1, time.Second, metrics, WithClock(clock))
+	bucket.Allow()
+	done := make(chan error)
+	go func() { done <- bucket.Wait(context.Background(), 1) }()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	writeExposition(&b, prometheusFormat, []Source{{Metrics: metrics}})
+	for _, want := range []string{
+		"ratelimit_wait_duration_seconds_bucket{le=\"0.5\"} 0\n",
+		"ratelimit_wait_duration_seconds_bucket{le=\"1\"} 1\n",
+		"ratelimit_wait_duration_seconds_sum 1\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected exposition to contain %q, got:\n%s", want, b.String())
+		}
+	}
+	if got := labels(Source{Name: "a\"b\\c\nd"}); got != `{limiter="a\"b\\c\nd"}` {
+		t.Errorf("expected escaped label, got %s", got)
+	}
+}
+func TestExpositionHandler_Keyed(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	metrics := &Metrics{}
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(1, time.Hour, metrics, WithClock(clock))
+	}, time.Hour, WithClock(clock), WithShards(1), WithMaxKeys(2, EvictOldest))
+	defer store.Close()
+	for _, key := range []string{"a", "b", "c", "a"} {
+		store.Allow(key)
+	}
+	handler := ExpositionHandler(Source{Name: "clients", Metrics: metrics, Keyed: store})
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	for _, want := range []string{
+		`ratelimit_tracked_keys{limiter="clients"} 2` + "\n",
+		"# TYPE ratelimit_key_evictions counter\n",
+		`ratelimit_key_evictions_total{limiter="clients",reason="capacity"} 2` + "\n",
+		`ratelimit_key_refusals_total{limiter="clients"} 0` + "\n",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected body to contain %q, got:\n%s", want, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type FixedWindowCounter struct {
+	limit          int
+	windowDuration time.Duration
+	count          int
+	resetTime      time.Time
+	rolls          int
+	mutex          sync.Mutex
+	metrics        *Metrics
+	clock          Clock
+}
+
+func NewFixedWindowCounter(limit int, windowDuration time.Duration, metrics *Metrics, opts ...Option) (*FixedWindowCounter, error) {
+	if limit <= 0 {
+		return nil, ErrInvalidLimit
+	}
+	if windowDuration <= 0 {
+		return nil, ErrInvalidWindow
+	}
+	o := buildOptions(opts)
+	return &FixedWindowCounter{
+		limit:          limit,
+		windowDuration: windowDuration,
+		count:          0,
+		resetTime:      o.clock.Now().Add(windowDuration),
+		metrics:        metrics,
+		clock:          o.clock,
+	}, nil
+}
+func (fw *FixedWindowCounter) roll(now time.Time) {
+	for !now.Before(fw.resetTime) {
+		fw.count = max(fw.count-fw.limit, 0)
+		fw.rolls++
+		if fw.count == 0 {
+			fw.resetTime = now.Add(fw.windowDuration)
+			return
+		}
+		fw.resetTime = fw.resetTime.Add(fw.windowDuration)
+	}
+}
+func (fw *FixedWindowCounter) slot(now time.Time, n int) (int, time.Time) {
+	start := fw.count
+	if n <= 0 {
+		return start, now
+	}
+	if start/fw.limit != (start+n-1)/fw.limit {
+		start = (start/fw.limit + 1) * fw.limit
+	}
+	windows := start / fw.limit
+	if windows == 0 {
+		return start, now
+	}
+	return start, fw.resetTime.Add(time.Duration(windows-1) * fw.windowDuration)
+}
+func (fw *FixedWindowCounter) Allow() bool {
+	return fw.AllowN(1).Allowed
+}
+func (fw *FixedWindowCounter) AllowN(n int) Decision {
+	start := time.Now()
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	now := fw.clock.Now()
+	fw.roll(now)
+	d := Decision{Limit: fw.limit, Window: fw.windowDuration}
+	if n >= 0 && fw.count+n <= fw.limit {
+		fw.count += n
+		d.Allowed = true
+	} else if n < 0 || n > fw.limit {
+		d.RetryAfter = InfDuration
+	} else {
+		_, at := fw.slot(now, n)
+		d.RetryAfter = at.Sub(now)
+	}
+	d.Remaining = max(fw.limit-fw.count, 0)
+	d.ResetAt = fw.resetTime
+	fw.metrics.record(d.Allowed, start)
+	return d
+}
+func (fw *FixedWindowCounter) Reserve(n int) *Reservation {
+	return fw.reserveN(fw.clock.Now(), n, InfDuration)
+}
+func (fw *FixedWindowCounter) Wait(ctx context.Context, n int) error {
+	return waitN(ctx, fw.clock, fw.metrics, fw.reserveN, n)
+}
+func (fw *FixedWindowCounter) reserveN(now time.Time, n int, maxWait time.Duration) *Reservation {
+	start := time.Now()
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.roll(now)
+	r := &Reservation{limit: fw.limit, tokens: n, clock: fw.clock}
+	if n >= 0 && n <= fw.limit {
+		start, at := fw.slot(now, n)
+		if wait := at.Sub(now); wait <= maxWait {
+			before, rolls := fw.count, fw.rolls
+			fw.count = start + n
+			r.ok = true
+			r.timeToAct = now.Add(wait)
+			r.refund = func(now time.Time) { fw.cancel(r, now, before, start+n, rolls) }
+		}
+	}
+	fw.metrics.record(r.ok, start)
+	return r
+}
+func (fw *FixedWindowCounter) cancel(r *Reservation, now time.Time, before, after, rolls int) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	if r.canceled || now.After(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+	fw.roll(now)
+	shift := (fw.rolls - rolls) * fw.limit
+	if fw.count == after-shift {
+		fw.count = max(before-shift, 0)
+		return
+	}
+	fw.count = max(fw.count-r.tokens, 0)
+}
+func (fw *FixedWindowCounter) level() (string, float64) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.roll(fw.clock.Now())
+	return "ratelimit_window_fill", float64(min(fw.count, fw.limit)) / float64(fw.limit)
+}
+func (fw *FixedWindowCounter) reconfigure(cfg Config) bool {
+	if cfg.Algorithm != AlgorithmFixedWindowCounter {
+		return false
+	}
+	fw.SetCapacity(cfg.Limit)
+	fw.SetWindow(cfg.Window)
+	return true
+}
+func (fw *FixedWindowCounter) SetCapacity(limit int) error {
+	if limit <= 0 {
+		return ErrInvalidLimit
+	}
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.roll(fw.clock.Now())
+	fw.limit = limit
+	return nil
+}
+func (fw *FixedWindowCounter) SetWindow(window time.Duration) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	now := fw.clock.Now()
+	fw.roll(now)
+	fw.windowDuration = window
+	if end := now.Add(window); end.Before(fw.resetTime) {
+		fw.resetTime = end
+	}
+}
+func (fw *FixedWindowCounter) credit(n int) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.roll(fw.clock.Now())
+	fw.count = max(fw.count-n, 0)
+}
+func (fw *FixedWindowCounter) state() State {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	fw.roll(fw.clock.Now())
+	return State{Limit: fw.limit, Window: fw.windowDuration, Gauge: "count", Level: float64(fw.count)}
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFixedWindowCounter_Allow(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	counter, _ := NewFixedWindowCounter(2, time.Second, metrics, WithClock(clock))
+	if !counter.Allow() {
+		t.Fatal("expected to allow the first request")
+	}
+	if !counter.Allow() {
+		t.Fatal("expected to allow the second request")
+	}
+	if counter.Allow() {
+		t.Fatal("expected to reject the third request")
+	}
+	clock.Advance(1 * time.Second)
+	if !counter.Allow() {
+		t.Fatal("expected to allow a request after window reset")
+	}
+}
+func TestRateLimitReset(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	counter, _ := NewFixedWindowCounter(1, time.Second, metrics, WithClock(clock))
+	if !counter.Allow() {
+		t.Fatal("expected to allow the first request")
+	}
+	clock.Advance(2 * time.Second)
+	if !counter.Allow() {
+		t.Fatal("expected to allow a request after the rate limit window has reset")
+	}
+}
+func TestFixedWindowCounter_Decision(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	counter, _ := NewFixedWindowCounter(2, time.Minute, nil, WithClock(clock))
+	if d := counter.AllowN(1); !d.Allowed || d.Remaining != 1 || !d.ResetAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected decision for the first request: %+v", d)
+	}
+	counter.AllowN(1)
+	clock.Advance(45 * time.Second)
+	d := counter.AllowN(1)
+	if d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected an exhausted window to reject, got %+v", d)
+	}
+	if d.RetryAfter != 15*time.Second {
+		t.Errorf("RetryAfter = %v, want 15s", d.RetryAfter)
+	}
+}
+func TestFixedWindowCounter_AllowN(t *testing.T) {
+	counter, _ := NewFixedWindowCounter(10, time.Minute, nil)
+	if d := counter.AllowN(7); !d.Allowed || d.Remaining != 3 {
+		t.Fatalf("unexpected decision for AllowN(7): %+v", d)
+	}
+	if counter.AllowN(4).Allowed {
+		t.Fatal("expected AllowN(4) to exceed the window")
+	}
+	if !counter.AllowN(3).Allowed {
+		t.Error("expected AllowN(3) to use the rest of the window")
+	}
+}
+func TestFixedWindowCounter_Invalid(t *testing.T) {
+	if _, err := NewFixedWindowCounter(0, time.Minute, nil); err != ErrInvalidLimit {
+		t.Errorf("expected a zero limit to be rejected, got %v", err)
+	}
+	if _, err := NewFixedWindowCounter(1, 0, nil); err != ErrInvalidWindow {
+		t.Errorf("expected a zero window to be rejected, got %v", err)
+	}
+	counter, _ := NewFixedWindowCounter(2, time.Minute, nil)
+	counter.AllowN(2)
+	if err := counter.SetCapacity(0); err != ErrInvalidLimit {
+		t.Errorf("expected a zero capacity to be rejected, got %v", err)
+	}
+	if d := counter.AllowN(1); d.Allowed || d.Limit != 2 || d.RetryAfter <= 0 {
+		t.Errorf("expected the rejected capacity to leave the counter alone, got %+v", d)
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/cdrcstcs/CV-RateLimiter/ratelimit
+
+go 1.21.5
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,70 @@
+package ratelimit
+
+import "net/http"
+
+func RequestHandler(l Limiter, next http.HandlerFunc, opts ...Option) http.HandlerFunc {
+	o := buildOptions(opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := l.AllowN(max(o.cost(r), 1))
+		WriteHeaders(w.Header(), d, o.clock.Now(), o.headers)
+		if !d.Allowed {
+			o.reject(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type KeyedRule struct {
+	Limiter *KeyedLimiter
+	Key     KeyFunc
+	Match   func(r *http.Request) bool
+}
+
+func KeyedRequestHandler(l *KeyedLimiter, key KeyFunc, next http.HandlerFunc, opts ...Option) http.HandlerFunc {
+	return KeyedRulesHandler([]KeyedRule{{Limiter: l, Key: key}}, next, opts...)
+}
+func KeyedRulesHandler(rules []KeyedRule, next http.HandlerFunc, opts ...Option) http.HandlerFunc {
+	o := buildOptions(opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		var matched []KeyedRule
+		var keys []string
+		for _, rule := range rules {
+			if rule.Match != nil && !rule.Match(r) {
+				continue
+			}
+			k, err := rule.Key(r)
+			if err != nil {
+				http.Error(w, "Unable to identify client", http.StatusBadRequest)
+				return
+			}
+			matched, keys = append(matched, rule), append(keys, k)
+		}
+		if len(matched) == 0 {
+			next(w, r)
+			return
+		}
+		n := max(o.cost(r), 1)
+		admitted := make([]Limiter, 0, len(matched))
+		var d Decision
+		for i, rule := range matched {
+			l := rule.Limiter.Get(keys[i])
+			if d = l.AllowN(n); !d.Allowed {
+				for _, l := range admitted {
+					Credit(l, n)
+				}
+				break
+			}
+			admitted = append(admitted, l)
+		}
+		WriteHeaders(w.Header(), d, o.clock.Now(), o.headers)
+		if !d.Allowed {
+			o.reject(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+func rejectTooManyRequests(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+}
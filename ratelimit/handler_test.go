@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestHandler(t *testing.T) {
+	bucket := NewTokenBucket(1, time.Minute, nil)
+	handler := RequestHandler(bucket, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Request allowed\n")
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "Request allowed\n" {
+		t.Errorf("unexpected first response: %d %q", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+}
+func TestRequestHandler_Headers(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(2, 30*time.Second, nil, WithClock(clock))
+	handler := RequestHandler(bucket, func(w http.ResponseWriter, r *http.Request) {}, WithClock(clock), WithHeaders(DefaultHeaders|HeaderLegacy))
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if i < 2 {
+			if got := rr.Header().Get("RateLimit-Remaining"); got != fmt.Sprint(1-i) {
+				t.Errorf("request %d: RateLimit-Remaining = %q, want %d", i+1, got, 1-i)
+			}
+			continue
+		}
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "30" {
+			t.Errorf("Retry-After = %q, want 30", got)
+		}
+		if got := rr.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+			t.Errorf("RateLimit-Policy = %q, want 2;w=60", got)
+		}
+		if got := rr.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+		}
+	}
+}
+func TestRequestHandler_Cost(t *testing.T) {
+	bucket := NewTokenBucket(10, time.Minute, nil)
+	handler := RequestHandler(bucket, func(w http.ResponseWriter, r *http.Request) {}, WithCost(func(r *http.Request) int {
+		switch r.URL.Path {
+		case "/export":
+			return 8
+		case "/free":
+			return -5
+		}
+		return 1
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "2" {
+		t.Fatalf("unexpected response to an expensive request: %d remaining=%s", rr.Code, rr.Header().Get("RateLimit-Remaining"))
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the second export to be rejected, got %v", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/free", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("expected a non-positive cost to be charged as 1, got %v remaining=%s", rr.Code, rr.Header().Get("RateLimit-Remaining"))
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a cheap request to still fit, got %v", rr.Code)
+	}
+}
+func TestMetricsHandler(t *testing.T) {
+	metrics := &Metrics{Allowed: 10, Rejected: 2}
+	rr := httptest.NewRecorder()
+	MetricsHandler(metrics).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	expected := "Total requests: 10\nRejected requests: 2\n"
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+func TestKeyedRequestHandler(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(string) Limiter {
+		return NewTokenBucket(1, time.Minute, nil, WithClock(clock))
+	}, time.Hour, WithClock(clock))
+	defer store.Close()
+	handler := KeyedRequestHandler(store, Header("X-API-Key"), func(w http.ResponseWriter, r *http.Request) {}, WithClock(clock), WithRejectHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	codes := []int{}
+	for _, apiKey := range []string{"a", "a", "b", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	want := []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+	if fmt.Sprint(codes) != fmt.Sprint(want) {
+		t.Errorf("expected status codes %v, got %v", want, codes)
+	}
+}
+func TestKeyedRulesHandler(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := func(capacity int) *KeyedLimiter {
+		return NewKeyedLimiter(func(string) Limiter {
+			return NewTokenBucket(capacity, time.Minute, nil, WithClock(clock))
+		}, time.Hour, WithClock(clock))
+	}
+	global, writes, reads := store(5), store(1), store(5)
+	defer global.Close()
+	defer writes.Close()
+	defer reads.Close()
+	method := func(m string) func(*http.Request) bool {
+		return func(r *http.Request) bool { return r.Method == m }
+	}
+	handler := KeyedRulesHandler([]KeyedRule{
+		{Limiter: global, Key: RemoteIP},
+		{Limiter: writes, Key: RemoteIP, Match: method(http.MethodPost)},
+		{Limiter: reads, Key: Header("X-API-Key"), Match: method(http.MethodGet)},
+	}, func(w http.ResponseWriter, r *http.Request) {}, WithClock(clock))
+	codes := []int{}
+	for _, m := range []string{http.MethodPost, http.MethodPost, http.MethodPost, http.MethodGet} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(m, "/", nil))
+		codes = append(codes, rr.Code)
+	}
+	want := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusBadRequest}
+	if fmt.Sprint(codes) != fmt.Sprint(want) {
+		t.Errorf("expected status codes %v, got %v", want, codes)
+	}
+	l, _ := global.Peek("192.0.2.1")
+	if state, _ := Inspect(l); state.Level != 4 {
+		t.Errorf("expected rejected and unidentified requests to leave the global budget untouched, got %v tokens", state.Level)
+	}
+}
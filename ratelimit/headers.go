@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type HeaderStyle uint8
+
+const (
+	HeaderRetryAfter HeaderStyle = 1 << iota
+	HeaderIETF
+	HeaderLegacy
+	HeadersNone    HeaderStyle = 0
+	DefaultHeaders             = HeaderRetryAfter | HeaderIETF
+)
+
+func WriteHeaders(h http.Header, d Decision, now time.Time, style HeaderStyle) {
+	remaining := strconv.Itoa(max(d.Remaining, 0))
+	limit := strconv.Itoa(d.Limit)
+	reset := seconds(d.ResetAt.Sub(now))
+	if style&HeaderIETF != 0 {
+		h.Set("RateLimit-Limit", limit)
+		h.Set("RateLimit-Remaining", remaining)
+		h.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+		if d.Window > 0 {
+			h.Set("RateLimit-Policy", limit+";w="+strconv.FormatInt(seconds(d.Window), 10))
+		}
+	}
+	if style&HeaderLegacy != 0 {
+		h.Set("X-RateLimit-Limit", limit)
+		h.Set("X-RateLimit-Remaining", remaining)
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+reset, 10))
+	}
+	if style&HeaderRetryAfter != 0 && !d.Allowed && d.RetryAfter != InfDuration {
+		h.Set("Retry-After", strconv.FormatInt(max(seconds(d.RetryAfter), 1), 10))
+	}
+}
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWriteHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	d := Decision{
+		Allowed:    false,
+		Limit:      10,
+		Remaining:  0,
+		ResetAt:    now.Add(1500 * time.Millisecond),
+		RetryAfter: 200 * time.Millisecond,
+		Window:     time.Minute,
+	}
+	h := http.Header{}
+	WriteHeaders(h, d, now, DefaultHeaders|HeaderLegacy)
+	expected := map[string]string{
+		"RateLimit-Limit":       "10",
+		"RateLimit-Remaining":   "0",
+		"RateLimit-Reset":       "2",
+		"RateLimit-Policy":      "10;w=60",
+		"X-RateLimit-Limit":     "10",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     "1700000002",
+		"Retry-After":           "1",
+	}
+	for name, want := range expected {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+func TestWriteHeaders_Style(t *testing.T) {
+	now := time.Now()
+	d := Decision{Allowed: true, Limit: 5, Remaining: 4, ResetAt: now}
+	h := http.Header{}
+	WriteHeaders(h, d, now, HeaderLegacy)
+	if h.Get("RateLimit-Limit") != "" || h.Get("Retry-After") != "" {
+		t.Errorf("expected only legacy headers, got %v", h)
+	}
+	if h.Get("X-RateLimit-Remaining") != "4" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 4", h.Get("X-RateLimit-Remaining"))
+	}
+	h = http.Header{}
+	WriteHeaders(h, d, now, HeadersNone)
+	if len(h) != 0 {
+		t.Errorf("expected no headers, got %v", h)
+	}
+}
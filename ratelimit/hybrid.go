@@ -0,0 +1,217 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Refunder interface {
+	Refund(ctx context.Context, key string, cfg Config, n int) error
+}
+type HybridStats struct {
+	Served   int `json:"served"`
+	Borrowed int `json:"borrowed"`
+	Calls    int `json:"calls"`
+	Leased   int `json:"leased"`
+	Returned int `json:"returned"`
+	Expired  int `json:"expired"`
+	Debt     int `json:"debt"`
+}
+type HybridLimiter struct {
+	backend   Backend
+	key       string
+	cfg       Config
+	lease     int
+	overshoot int
+	bucket    *TokenBucket
+	expiresAt time.Time
+	debt      int
+	retryAt   time.Time
+	refill    chan struct{}
+	closed    bool
+	mutex     sync.Mutex
+	stats     HybridStats
+	metrics   *Metrics
+	clock     Clock
+	ctx       context.Context
+	timeout   time.Duration
+}
+
+func NewHybridLimiter(backend Backend, key string, cfg Config, lease, overshoot int, metrics *Metrics, opts ...Option) (*HybridLimiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	o := buildOptions(opts)
+	bucket := NewTokenBucketRate(cfg.Limit, 0, nil, WithClock(o.clock))
+	bucket.AllowN(cfg.Limit)
+	return &HybridLimiter{
+		backend:   backend,
+		key:       key,
+		cfg:       cfg,
+		lease:     min(max(lease, 1), cfg.Limit),
+		overshoot: max(overshoot, 0),
+		bucket:    bucket,
+		metrics:   metrics,
+		clock:     o.clock,
+		ctx:       o.ctx,
+		timeout:   o.timeout,
+	}, nil
+}
+func (l *HybridLimiter) Allow() bool {
+	return l.AllowN(1).Allowed
+}
+func (l *HybridLimiter) AllowN(n int) Decision {
+	start := time.Now()
+	d := l.admit(n)
+	l.metrics.record(d.Allowed, start)
+	return d
+}
+
+// admit serves n from the leased tokens and never waits for the backend: when
+// the lease runs low it starts a refill in the background, and until that
+// lands requests are borrowed against the overshoot or rejected.
+func (l *HybridLimiter) admit(n int) Decision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	d := Decision{Limit: l.cfg.Limit, Window: l.cfg.Window}
+	now := l.clock.Now()
+	l.expire(now)
+	held := l.held()
+	if n >= 0 && n <= l.cfg.Limit && (held*2 < l.lease || n > held) && l.refill == nil && !l.closed && !now.Before(l.retryAt) {
+		l.refill = make(chan struct{})
+		go l.fetch(min(max(l.lease, n-held)+l.debt, l.cfg.Limit-held))
+	}
+	switch {
+	case n < 0 || n > l.cfg.Limit:
+		d.RetryAfter = InfDuration
+	case n <= held:
+		l.bucket.AllowN(n)
+		l.stats.Served += n
+		d.Allowed = true
+	case held == 0 && l.debt+n <= l.overshoot && l.refill != nil:
+		l.debt += n
+		l.stats.Borrowed += n
+		d.Allowed = true
+	case now.Before(l.retryAt):
+		d.RetryAfter = l.retryAt.Sub(now)
+	default:
+		d.RetryAfter = pollInterval
+	}
+	d.Remaining = l.held()
+	return d
+}
+func (l *HybridLimiter) held() int {
+	state, _ := Inspect(l.bucket)
+	return int(state.Level + tokenEpsilon)
+}
+
+// expire drops the lease once the backend window it was taken from has reset;
+// refunding it would hand the new window tokens it never granted.
+func (l *HybridLimiter) expire(now time.Time) {
+	if l.expiresAt.IsZero() || now.Before(l.expiresAt) {
+		return
+	}
+	held := l.held()
+	l.bucket.AllowN(held)
+	l.stats.Expired += held
+	l.expiresAt = time.Time{}
+}
+func (l *HybridLimiter) fetch(want int) {
+	got, d := l.take(want)
+	if got == 0 && d.Remaining > 0 {
+		got, d = l.take(min(d.Remaining, want))
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.stats.Leased += got
+	repaid := min(got, l.debt)
+	l.debt -= repaid
+	Credit(l.bucket, got-repaid)
+	if got > 0 && !d.ResetAt.IsZero() {
+		l.expiresAt = d.ResetAt
+	}
+	l.retryAt = time.Time{}
+	if retry := max(d.RetryAfter, pollInterval); got == 0 {
+		if retry == InfDuration {
+			retry = pollInterval
+		}
+		l.retryAt = l.clock.Now().Add(retry)
+	}
+	close(l.refill)
+	l.refill = nil
+}
+func (l *HybridLimiter) take(n int) (int, Decision) {
+	ctx, cancel := l.context()
+	defer cancel()
+	d, err := l.backend.Take(ctx, l.key, l.cfg, n)
+	l.mutex.Lock()
+	l.stats.Calls++
+	l.mutex.Unlock()
+	if err != nil || !d.Allowed {
+		return 0, d
+	}
+	return n, d
+}
+func (l *HybridLimiter) context() (context.Context, context.CancelFunc) {
+	if l.timeout > 0 {
+		return context.WithTimeout(l.ctx, l.timeout)
+	}
+	return context.WithCancel(l.ctx)
+}
+func (l *HybridLimiter) Reserve(n int) *Reservation {
+	return reserve(l, l.clock, n)
+}
+func (l *HybridLimiter) Wait(ctx context.Context, n int) error {
+	return wait(ctx, l.clock, l.metrics, l, n)
+}
+func (l *HybridLimiter) Sync() {
+	l.mutex.Lock()
+	refill := l.refill
+	l.mutex.Unlock()
+	if refill != nil {
+		<-refill
+	}
+}
+
+// Ready reports whether a rejection from AllowN reflects the backend budget,
+// rather than a refill still in flight or a closed limiter.
+func (l *HybridLimiter) Ready() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.refill == nil && !l.closed
+}
+
+// Close stops new refills before it waits for the one in flight, so every
+// token leased from the backend is either spent or returned.
+func (l *HybridLimiter) Close() error {
+	l.mutex.Lock()
+	l.closed = true
+	l.mutex.Unlock()
+	l.Sync()
+	l.mutex.Lock()
+	l.expire(l.clock.Now())
+	unused := l.held()
+	l.bucket.AllowN(unused)
+	l.mutex.Unlock()
+	refunder, ok := l.backend.(Refunder)
+	if unused == 0 || !ok {
+		return nil
+	}
+	ctx, cancel := l.context()
+	defer cancel()
+	if err := refunder.Refund(ctx, l.key, l.cfg, unused); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.stats.Returned += unused
+	l.mutex.Unlock()
+	return nil
+}
+func (l *HybridLimiter) Stats() HybridStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := l.stats
+	stats.Debt = l.debt
+	return stats
+}
@@ -0,0 +1,280 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type budgetBackend struct {
+	mutex    sync.Mutex
+	limit    int
+	used     int
+	calls    int
+	refunded int
+	gate     chan struct{}
+	clock    Clock
+	window   time.Duration
+	start    time.Time
+}
+
+func (b *budgetBackend) Take(ctx context.Context, key string, cfg Config, n int) (Decision, error) {
+	if b.gate != nil {
+		<-b.gate
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.calls++
+	d := Decision{Limit: b.limit}
+	if b.window > 0 {
+		if now := b.clock.Now(); !now.Before(b.start.Add(b.window)) {
+			b.start, b.used = now, 0
+		}
+		d.ResetAt = b.start.Add(b.window)
+	}
+	if b.used+n <= b.limit {
+		b.used += n
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Minute
+	}
+	d.Remaining = b.limit - b.used
+	return d, nil
+}
+func (b *budgetBackend) Refund(ctx context.Context, key string, cfg Config, n int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used -= n
+	b.refunded += n
+	return nil
+}
+func (b *budgetBackend) counts() (int, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.used, b.calls
+}
+func TestHybridLimiter_Lease(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	backend := &budgetBackend{limit: 100}
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 100, Window: time.Minute}
+	metrics := &Metrics{}
+	l, err := NewHybridLimiter(backend, "a", cfg, 10, 0, metrics, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := l.AllowN(1); d.Allowed || d.RetryAfter != pollInterval {
+		t.Fatalf("expected the first request to be rejected while the lease is fetched, got %+v", d)
+	}
+	for i := 0; i < 100; i++ {
+		l.Sync()
+		if !l.Allow() {
+			t.Fatalf("expected request %d to be served from a lease", i+1)
+		}
+	}
+	l.Sync()
+	d := l.AllowN(1)
+	if d.Allowed || d.RetryAfter != time.Minute {
+		t.Errorf("expected the shared budget to be exhausted, got %+v", d)
+	}
+	_, calls := backend.counts()
+	for i := 0; i < 50; i++ {
+		l.Allow()
+	}
+	if _, after := backend.counts(); after != calls || calls > 12 {
+		t.Errorf("expected denied requests to back off instead of calling the backend, got %d then %d calls", calls, after)
+	}
+	if d := l.AllowN(101); d.Allowed || d.RetryAfter != InfDuration {
+		t.Errorf("expected a request above the limit to never fit, got %+v", d)
+	}
+	if allowed, rejected := metrics.Counts(); allowed != 100 || rejected != 53 {
+		t.Errorf("unexpected counts %d/%d", allowed, rejected)
+	}
+	backend = &budgetBackend{limit: 100}
+	l, _ = NewHybridLimiter(NewResilientBackend(backend, FailClosed, WithClock(clock)), "a", cfg, 10, 0, nil, WithClock(clock))
+	l.Allow()
+	l.Sync()
+	l.Allow()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := backend.counts(); used != 1 || l.Stats().Returned != 9 {
+		t.Errorf("expected unused tokens to be returned, got %d used and %+v", used, l.Stats())
+	}
+	if _, err := NewHybridLimiter(backend, "a", Config{Algorithm: AlgorithmTokenBucket}, 10, 0, nil); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+}
+func TestHybridLimiter_Weighted(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	backend := &budgetBackend{limit: 30}
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 30, Window: time.Minute}
+	l, _ := NewHybridLimiter(backend, "a", cfg, 10, 0, nil, WithClock(clock))
+	l.Allow()
+	l.Sync()
+	for i := 0; i < 5; i++ {
+		l.Allow()
+	}
+	if stats := l.Stats(); stats.Leased != 10 || stats.Served != 5 {
+		t.Fatalf("expected 5 tokens to be left from the first lease, got %+v", stats)
+	}
+	for i := 0; i < 2; i++ {
+		if d := l.AllowN(8); d.Allowed || d.RetryAfter != pollInterval {
+			t.Fatalf("expected weighted request %d to be rejected while it fetches the tokens it is short of, got %+v", i+1, d)
+		}
+		l.Sync()
+		if d := l.AllowN(8); !d.Allowed {
+			t.Fatalf("expected weighted request %d to be served from the new lease, got %+v", i+1, d)
+		}
+	}
+	if d := l.AllowN(8); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("expected the leftover lease to serve a weighted request, got %+v", d)
+	}
+	l.AllowN(8)
+	l.Sync()
+	if d := l.AllowN(8); d.Allowed || d.RetryAfter != time.Minute || d.Remaining != 1 {
+		t.Errorf("expected the shared budget to be exhausted, got %+v", d)
+	}
+	if used, calls := backend.counts(); used != 30 || calls != 4 {
+		t.Errorf("expected the whole budget to be leased, got %d used in %d calls", used, calls)
+	}
+}
+func TestHybridLimiter_Overshoot(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	backend := &budgetBackend{limit: 20, gate: make(chan struct{})}
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 20, Window: time.Minute}
+	l, _ := NewHybridLimiter(backend, "a", cfg, 10, 3, nil, WithClock(clock))
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("expected request %d to be borrowed while the first lease is in flight", i+1)
+		}
+	}
+	if d := l.AllowN(1); d.Allowed || d.RetryAfter != pollInterval {
+		t.Errorf("expected a request beyond the overshoot to be rejected without waiting, got %+v", d)
+	}
+	if stats := l.Stats(); stats.Borrowed != 3 || stats.Debt != 3 {
+		t.Errorf("expected the overshoot to be borrowed, got %+v", stats)
+	}
+	backend.gate <- struct{}{}
+	l.Sync()
+	if !l.Allow() {
+		t.Error("expected the lease to serve requests once it lands")
+	}
+	if stats := l.Stats(); stats.Debt != 0 || stats.Leased != 10 || stats.Served != 1 || l.held() != 6 {
+		t.Errorf("expected borrowed tokens to be repaid from the lease, got %+v and %d held", stats, l.held())
+	}
+}
+func TestHybridLimiter_Expiry(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	backend := &budgetBackend{limit: 10, clock: clock, window: time.Minute, start: clock.Now()}
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 10, Window: time.Minute}
+	l, _ := NewHybridLimiter(backend, "a", cfg, 10, 0, nil, WithClock(clock))
+	l.Allow()
+	l.Sync()
+	for i := 0; i < 3; i++ {
+		l.Allow()
+	}
+	clock.Advance(time.Minute)
+	if d := l.AllowN(1); d.Allowed {
+		t.Errorf("expected the lease to expire with the backend window, got %+v", d)
+	}
+	l.Sync()
+	if !l.Allow() {
+		t.Error("expected a lease from the new window")
+	}
+	if stats := l.Stats(); stats.Expired != 7 || stats.Leased != 20 {
+		t.Errorf("expected the unused lease to be dropped, got %+v", stats)
+	}
+	clock.Advance(time.Minute)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := backend.counts(); used != 10 || backend.refunded != 0 {
+		t.Errorf("expected an expired lease not to be refunded into a later window, got %d used and %d refunded", used, backend.refunded)
+	}
+}
+func TestHybridLimiter_Close(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		backend := &budgetBackend{limit: 1000}
+		cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 1000, Window: time.Minute}
+		l, _ := NewHybridLimiter(backend, "a", cfg, 10, 0, nil)
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					l.Allow()
+				}
+			}()
+		}
+		l.Allow()
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		l.Sync()
+		stats := l.Stats()
+		if used, _ := backend.counts(); used != stats.Served || l.held() != 0 {
+			t.Fatalf("expected every leased token to be spent or returned after close, got %d used and %+v", used, stats)
+		}
+	}
+}
+func TestHybridLimiter_Accuracy(t *testing.T) {
+	const (
+		limit     = 1000
+		replicas  = 4
+		lease     = 20
+		overshoot = 10
+		requests  = 4000
+	)
+	clock := NewManualClock(time.Now())
+	backend := &budgetBackend{limit: limit}
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: limit, Window: time.Minute}
+	limiters := make([]*HybridLimiter, replicas)
+	for i := range limiters {
+		limiters[i], _ = NewHybridLimiter(backend, "a", cfg, lease, overshoot, nil, WithClock(clock))
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	admitted := 0
+	for g := 0; g < 2*replicas; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < requests/(2*replicas); i++ {
+				l := limiters[g%replicas]
+				if !l.Allow() {
+					l.Sync()
+					continue
+				}
+				mutex.Lock()
+				admitted++
+				mutex.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+	held := 0
+	for _, l := range limiters {
+		l.Sync()
+		held += l.held()
+	}
+	_, calls := backend.counts()
+	t.Logf("admitted %d of %d requests against a limit of %d with %d backend calls", admitted, requests, limit, calls)
+	if admitted > limit+replicas*overshoot {
+		t.Errorf("expected overshoot to be bounded by %d, admitted %d", replicas*overshoot, admitted)
+	}
+	if admitted+held < limit {
+		t.Errorf("expected leased tokens to account for the whole budget, admitted %d and held %d", admitted, held)
+	}
+	if calls > requests/10 {
+		t.Errorf("expected leasing to save at least 90%% of backend calls, got %d for %d requests", calls, requests)
+	}
+	for _, l := range limiters {
+		l.Close()
+	}
+	if used, _ := backend.counts(); used != limit-held {
+		t.Errorf("expected held tokens to be refunded on close, got %d used", used)
+	}
+}
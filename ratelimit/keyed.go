@@ -0,0 +1,364 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultShards = 32
+	overflowKey   = "overflow"
+)
+
+var (
+	ErrKeyedFull = errors.New("ratelimit: keyed limiter is tracking its maximum number of keys")
+	ErrKeyBanned = errors.New("ratelimit: key is banned")
+)
+
+type FullPolicy int
+
+const (
+	EvictOldest FullPolicy = iota
+	ShareOverflow
+	RejectNew
+)
+
+type KeyedStats struct {
+	Size      int `json:"size"`
+	Created   int `json:"created"`
+	Evictions int `json:"evictions"`
+	Displaced int `json:"displaced"`
+	Overflows int `json:"overflows"`
+	Refusals  int `json:"refusals"`
+	Banned    int `json:"banned"`
+}
+type KeyedLimiter struct {
+	config   atomic.Pointer[keyedConfig]
+	mutex    sync.Mutex
+	ttl      time.Duration
+	clock    Clock
+	seed     maphash.Seed
+	shards   []*keyedShard
+	interval time.Duration
+	policy   FullPolicy
+	overflow atomic.Pointer[Limiter]
+	done     chan struct{}
+	stopped  chan struct{}
+	close    sync.Once
+}
+type keyedShard struct {
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	bans    map[string]time.Time
+	limit   int
+	stats   KeyedStats
+}
+type keyedConfig struct {
+	factory    func(key string) Limiter
+	adjustment Adjustment
+	keys       map[string]Adjustment
+}
+type keyedEntry struct {
+	key      string
+	limiter  Limiter
+	lastSeen time.Time
+}
+
+func NewKeyedLimiter(factory func(key string) Limiter, ttl time.Duration, opts ...Option) *KeyedLimiter {
+	o := buildOptions(opts)
+	shards := max(o.shards, 1)
+	if o.maxKeys > 0 {
+		shards = min(shards, o.maxKeys)
+	}
+	k := &KeyedLimiter{
+		ttl:      ttl,
+		clock:    o.clock,
+		seed:     maphash.MakeSeed(),
+		shards:   make([]*keyedShard, shards),
+		interval: max(ttl/3, time.Second),
+		policy:   o.full,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	k.config.Store(&keyedConfig{factory: factory})
+	for i := range k.shards {
+		k.shards[i] = &keyedShard{entries: make(map[string]*list.Element), order: list.New(), bans: make(map[string]time.Time)}
+		if o.maxKeys > 0 {
+			k.shards[i].limit = max(o.maxKeys/shards, 1)
+			if i < o.maxKeys%shards {
+				k.shards[i].limit++
+			}
+		}
+	}
+	if k.policy == ShareOverflow {
+		overflow := factory(overflowKey)
+		k.overflow.Store(&overflow)
+	}
+	go k.janitor(o.ctx)
+	return k
+}
+func (k *KeyedLimiter) janitor(ctx context.Context) {
+	defer close(k.stopped)
+	tick := max(k.interval/time.Duration(len(k.shards)), time.Millisecond)
+	last := k.clock.Now()
+	next := 0
+	for {
+		timer := k.clock.NewTimer(tick)
+		select {
+		case <-k.done:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		now := k.clock.Now()
+		due := min(max(int(now.Sub(last)/tick), 1), len(k.shards))
+		last = now
+		for i := 0; i < due; i++ {
+			k.shards[next].sweep(now, k.ttl)
+			next = (next + 1) % len(k.shards)
+		}
+	}
+}
+func (k *KeyedLimiter) Close() error {
+	k.close.Do(func() { close(k.done) })
+	<-k.stopped
+	return nil
+}
+func (k *KeyedLimiter) shard(key string) *keyedShard {
+	return k.shards[maphash.String(k.seed, key)%uint64(len(k.shards))]
+}
+func (k *KeyedLimiter) Get(key string) Limiter {
+	s := k.shard(key)
+	s.mutex.Lock()
+	l, found := k.lookup(s, key, k.clock.Now())
+	s.mutex.Unlock()
+	if found {
+		return l
+	}
+	var candidate Limiter
+	for {
+		cfg := k.config.Load()
+		candidate = cfg.build(key)
+		s.mutex.Lock()
+		if k.config.Load() == cfg {
+			break
+		}
+		s.mutex.Unlock()
+	}
+	defer s.mutex.Unlock()
+	now := k.clock.Now()
+	if l, found := k.lookup(s, key, now); found {
+		return l
+	}
+	if s.limit > 0 && len(s.entries) >= s.limit {
+		oldest := s.order.Back().Value.(*keyedEntry)
+		switch k.policy {
+		case ShareOverflow:
+			s.stats.Overflows++
+			return *k.overflow.Load()
+		case RejectNew:
+			s.stats.Refusals++
+			state, _ := Inspect(candidate)
+			return refusal{limit: state.Limit, retryAfter: max(oldest.lastSeen.Add(k.ttl).Sub(now), 0), err: ErrKeyedFull}
+		}
+		s.remove(oldest.key)
+		s.stats.Displaced++
+	}
+	e := &keyedEntry{key: key, limiter: candidate, lastSeen: now}
+	s.entries[key] = s.order.PushFront(e)
+	s.stats.Created++
+	return e.limiter
+}
+func (k *KeyedLimiter) lookup(s *keyedShard, key string, now time.Time) (Limiter, bool) {
+	if until, found := s.bans[key]; found {
+		if until.IsZero() || now.Before(until) {
+			return refusal{retryAfter: banRetry(now, until), err: ErrKeyBanned}, true
+		}
+		delete(s.bans, key)
+	}
+	if el, found := s.entries[key]; found {
+		e := el.Value.(*keyedEntry)
+		e.lastSeen = now
+		s.order.MoveToFront(el)
+		return e.limiter, true
+	}
+	return nil, false
+}
+func (k *KeyedLimiter) Peek(key string) (Limiter, bool) {
+	s := k.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if el, found := s.entries[key]; found {
+		return el.Value.(*keyedEntry).limiter, true
+	}
+	return nil, false
+}
+func (k *KeyedLimiter) Reset(key string) bool {
+	k.forget(key)
+	s := k.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.entries[key]; !found {
+		return false
+	}
+	s.remove(key)
+	return true
+}
+func (k *KeyedLimiter) Ban(key string, d time.Duration) {
+	s := k.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bans[key] = time.Time{}
+	if d > 0 {
+		s.bans[key] = k.clock.Now().Add(d)
+	}
+}
+func (k *KeyedLimiter) Unban(key string) bool {
+	s := k.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, found := s.bans[key]
+	delete(s.bans, key)
+	return found
+}
+func (k *KeyedLimiter) Banned(key string) (time.Time, bool) {
+	s := k.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	until, found := s.bans[key]
+	if found && !until.IsZero() && !k.clock.Now().Before(until) {
+		return time.Time{}, false
+	}
+	return until, found
+}
+func banRetry(now, until time.Time) time.Duration {
+	if until.IsZero() {
+		return InfDuration
+	}
+	return until.Sub(now)
+}
+func (k *KeyedLimiter) Allow(key string) bool {
+	return k.Get(key).Allow()
+}
+func (k *KeyedLimiter) AllowN(key string, n int) Decision {
+	return k.Get(key).AllowN(n)
+}
+func (k *KeyedLimiter) Reserve(key string, n int) *Reservation {
+	return k.Get(key).Reserve(n)
+}
+func (k *KeyedLimiter) Wait(ctx context.Context, key string, n int) error {
+	return k.Get(key).Wait(ctx, n)
+}
+func (k *KeyedLimiter) Reconfigure(factory func(key string) Limiter, update func(key string, l Limiter) Limiter) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	cfg := *k.config.Load()
+	cfg.factory = factory
+	k.config.Store(&cfg)
+	k.update(func(key string, l Limiter) Limiter {
+		l = update(key, l)
+		cfg.adjust(key, l)
+		return l
+	})
+}
+func (k *KeyedLimiter) update(update func(key string, l Limiter) Limiter) {
+	if overflow := k.overflow.Load(); overflow != nil {
+		next := update(overflowKey, *overflow)
+		k.overflow.Store(&next)
+	}
+	for _, s := range k.shards {
+		s.mutex.Lock()
+		for key, el := range s.entries {
+			e := el.Value.(*keyedEntry)
+			e.limiter = update(key, e.limiter)
+		}
+		s.mutex.Unlock()
+	}
+}
+func (c *keyedConfig) build(key string) Limiter {
+	l := c.factory(key)
+	c.adjust(key, l)
+	return l
+}
+func (c *keyedConfig) adjust(key string, l Limiter) {
+	c.adjustment.apply(l)
+	c.keys[key].apply(l)
+}
+func (k *KeyedLimiter) Sweep() int {
+	now := k.clock.Now()
+	evicted := 0
+	for _, s := range k.shards {
+		evicted += s.sweep(now, k.ttl)
+	}
+	return evicted
+}
+func (s *keyedShard) sweep(now time.Time, ttl time.Duration) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, until := range s.bans {
+		if !until.IsZero() && !now.Before(until) {
+			delete(s.bans, key)
+		}
+	}
+	evicted := 0
+	for el := s.order.Back(); el != nil; el = s.order.Back() {
+		e := el.Value.(*keyedEntry)
+		if now.Sub(e.lastSeen) <= ttl {
+			break
+		}
+		s.remove(e.key)
+		evicted++
+	}
+	s.stats.Evictions += evicted
+	return evicted
+}
+func (s *keyedShard) remove(key string) {
+	s.order.Remove(s.entries[key])
+	delete(s.entries, key)
+}
+func (k *KeyedLimiter) Len() int {
+	return k.Stats().Size
+}
+func (k *KeyedLimiter) Stats() KeyedStats {
+	var stats KeyedStats
+	for _, s := range k.shards {
+		s.mutex.Lock()
+		stats.Size += len(s.entries)
+		stats.Created += s.stats.Created
+		stats.Evictions += s.stats.Evictions
+		stats.Displaced += s.stats.Displaced
+		stats.Overflows += s.stats.Overflows
+		stats.Refusals += s.stats.Refusals
+		stats.Banned += len(s.bans)
+		s.mutex.Unlock()
+	}
+	return stats
+}
+
+type refusal struct {
+	limit      int
+	retryAfter time.Duration
+	err        error
+}
+
+func (r refusal) Allow() bool {
+	return false
+}
+func (r refusal) AllowN(n int) Decision {
+	return Decision{Limit: r.limit, RetryAfter: r.retryAfter}
+}
+func (r refusal) Reserve(n int) *Reservation {
+	return &Reservation{limit: r.limit, tokens: n, clock: RealClock{}}
+}
+func (r refusal) Wait(ctx context.Context, n int) error {
+	return r.err
+}
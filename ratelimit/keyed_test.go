@@ -0,0 +1,230 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiter_PerKey(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(2, time.Second, nil, WithClock(clock))
+	}, time.Minute, WithClock(clock))
+	defer store.Close()
+	for i := 0; i < 2; i++ {
+		if !store.Allow("a") {
+			t.Fatalf("expected request %d for key a to be allowed", i+1)
+		}
+	}
+	if store.Allow("a") {
+		t.Error("expected key a to be exhausted")
+	}
+	if !store.Allow("b") {
+		t.Error("expected key b to have its own budget")
+	}
+	if stats := store.Stats(); stats.Size != 2 || stats.Created != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+func TestKeyedLimiter_Eviction(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(key string) Limiter {
+		counter, _ := NewFixedWindowCounter(1, time.Hour, nil, WithClock(clock))
+		return counter
+	}, 3*time.Minute, WithClock(clock))
+	defer store.Close()
+	store.Allow("idle")
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Minute)
+	store.Allow("busy")
+	clock.BlockUntil(1)
+	if store.Len() != 2 {
+		t.Fatalf("expected both keys to survive the first sweep, got %d", store.Len())
+	}
+	clock.Advance(2 * time.Minute)
+	clock.BlockUntil(1)
+	if stats := store.Stats(); stats.Size != 1 || stats.Evictions != 1 {
+		t.Fatalf("expected the idle key to be evicted, got %+v", stats)
+	}
+	if !store.Allow("idle") {
+		t.Error("expected an evicted key to start with a fresh limiter")
+	}
+	if store.Allow("busy") {
+		t.Error("expected the retained key to keep its state")
+	}
+}
+func TestKeyedLimiter_IncrementalSweep(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(1, time.Second, nil, WithClock(clock))
+	}, 3*time.Minute, WithClock(clock), WithShards(4))
+	defer store.Close()
+	for i := 0; i < 100; i++ {
+		store.Allow(fmt.Sprint("client-", i))
+	}
+	for i := 0; i < 13; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(15 * time.Second)
+	}
+	clock.BlockUntil(1)
+	if stats := store.Stats(); stats.Evictions == 0 || stats.Evictions == 100 {
+		t.Fatalf("expected the janitor to sweep a single shard per tick, got %+v", stats)
+	}
+	for i := 0; i < 3; i++ {
+		clock.Advance(15 * time.Second)
+		clock.BlockUntil(1)
+	}
+	if stats := store.Stats(); stats.Size != 0 || stats.Evictions != 100 {
+		t.Errorf("expected every shard to be swept within one interval, got %+v", stats)
+	}
+}
+func TestKeyedLimiter_Concurrent(t *testing.T) {
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(10, time.Hour, nil)
+	}, time.Hour)
+	defer store.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				store.Allow(fmt.Sprint("client-", (g*200+i)%500))
+			}
+		}(g)
+	}
+	wg.Wait()
+	if stats := store.Stats(); stats.Size != 500 || stats.Created != 500 {
+		t.Errorf("expected 500 distinct clients, got %+v", stats)
+	}
+}
+func BenchmarkKeyedLimiter_Parallel(b *testing.B) {
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewAtomicTokenBucketRate(100, 1e6, nil)
+	}, time.Hour)
+	defer store.Close()
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = fmt.Sprint("client-", i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			store.Allow(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+func TestKeyedLimiter_MaxKeys(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	factory := func(key string) Limiter {
+		return NewTokenBucket(1, time.Hour, nil, WithClock(clock))
+	}
+	evicting := NewKeyedLimiter(factory, time.Hour, WithClock(clock), WithShards(1), WithMaxKeys(2, EvictOldest))
+	defer evicting.Close()
+	evicting.Allow("a")
+	evicting.Allow("b")
+	evicting.Get("a")
+	evicting.Allow("c")
+	if stats := evicting.Stats(); stats.Size != 2 || stats.Displaced != 1 {
+		t.Fatalf("expected one displaced key, got %+v", stats)
+	}
+	if evicting.Allow("a") {
+		t.Error("expected the recently used key to survive")
+	}
+	if !evicting.Allow("b") {
+		t.Error("expected the least recently used key to have been displaced")
+	}
+	overflow := NewKeyedLimiter(factory, time.Hour, WithClock(clock), WithShards(1), WithMaxKeys(1, ShareOverflow))
+	defer overflow.Close()
+	overflow.Allow("a")
+	if !overflow.Allow("b") || overflow.Allow("c") {
+		t.Error("expected new keys to share a single overflow limiter")
+	}
+	if stats := overflow.Stats(); stats.Size != 1 || stats.Overflows != 2 {
+		t.Errorf("expected two overflowed requests, got %+v", stats)
+	}
+	rejecting := NewKeyedLimiter(factory, time.Hour, WithClock(clock), WithShards(1), WithMaxKeys(1, RejectNew))
+	defer rejecting.Close()
+	rejecting.Allow("a")
+	clock.Advance(20 * time.Minute)
+	if d := rejecting.AllowN("b", 1); d.Allowed || d.Limit != 1 || d.RetryAfter != 40*time.Minute {
+		t.Errorf("expected a refusal until the oldest key expires, got %+v", d)
+	}
+	if err := rejecting.Wait(context.Background(), "b", 1); err != ErrKeyedFull {
+		t.Errorf("expected ErrKeyedFull, got %v", err)
+	}
+	if stats := rejecting.Stats(); stats.Refusals != 2 {
+		t.Errorf("expected two refusals, got %+v", stats)
+	}
+	var store *KeyedLimiter
+	store = NewKeyedLimiter(func(key string) Limiter {
+		store.Len()
+		return factory(key)
+	}, time.Hour, WithClock(clock), WithMaxKeys(1, EvictOldest))
+	defer store.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		if !store.Allow(key) {
+			t.Errorf("expected %s to get a limiter from a factory that reads the store", key)
+		}
+	}
+	if stats := store.Stats(); stats.Size != 1 || stats.Displaced != 2 {
+		t.Errorf("expected a single key across every shard, got %+v", stats)
+	}
+}
+func TestKeyedLimiter_Close(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(1, time.Second, nil, WithClock(clock))
+	}, time.Minute, WithClock(clock))
+	store.Allow("a")
+	clock.BlockUntil(1)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if store.Len() != 1 {
+		t.Errorf("expected a closed store to stop sweeping, got %d keys", store.Len())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	store = NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(1, time.Second, nil, WithClock(clock))
+	}, time.Minute, WithClock(clock), WithContext(ctx))
+	clock.BlockUntil(1)
+	cancel()
+	<-store.stopped
+}
+func TestKeyedLimiter_Reconfigure(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewKeyedLimiter(func(key string) Limiter {
+		return NewTokenBucket(2, time.Hour, nil, WithClock(clock))
+	}, time.Hour, WithClock(clock), WithMaxKeys(1, ShareOverflow))
+	defer store.Close()
+	store.AllowN("a", 2)
+	store.AllowN("b", 2)
+	cfg := Config{Algorithm: AlgorithmTokenBucket, Limit: 4, Rate: time.Hour}
+	store.Reconfigure(func(key string) Limiter {
+		l, _ := New(cfg, nil, WithClock(clock))
+		return l
+	}, func(key string, l Limiter) Limiter {
+		l, _ = Reconfigure(l, cfg, nil, WithClock(clock))
+		return l
+	})
+	if d := store.AllowN("a", 1); d.Allowed || d.Limit != 4 {
+		t.Errorf("expected an exhausted key to stay exhausted under the new limit, got %+v", d)
+	}
+	if d := store.AllowN("c", 1); d.Allowed || d.Limit != 4 {
+		t.Errorf("expected the overflow limiter to keep its state, got %+v", d)
+	}
+	clock.Advance(2 * time.Hour)
+	store.Sweep()
+	if d := store.AllowN("d", 4); !d.Allowed {
+		t.Errorf("expected new keys to use the new factory, got %+v", d)
+	}
+}
@@ -0,0 +1,228 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	ErrNoKey        = errors.New("ratelimit: request has no key")
+	compositeEscape = strings.NewReplacer(`\`, `\\`, "|", `\|`)
+)
+
+type KeyFunc func(r *http.Request) (string, error)
+
+func RemoteIP(r *http.Request) (string, error) {
+	addr, err := remoteAddr(r)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+func remoteAddr(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: invalid remote address %q", ErrNoKey, r.RemoteAddr)
+	}
+	return addr.Unmap(), nil
+}
+func ParseTrustedProxies(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("ratelimit: invalid trusted proxy %q: %w", cidr, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit: invalid trusted proxy %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+func ClientIP(trusted ...netip.Prefix) KeyFunc {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) (string, error) {
+		client, err := remoteAddr(r)
+		if err != nil {
+			return "", err
+		}
+		if !isTrusted(client) {
+			return client.String(), nil
+		}
+		hops := forwardedHops(r.Header)
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseHop(hops[i])
+			if !ok {
+				break
+			}
+			client = addr
+			if !isTrusted(addr) {
+				break
+			}
+		}
+		return client.String(), nil
+	}
+}
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, value := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	if value := h.Get("X-Real-IP"); value != "" {
+		return []string{value}
+	}
+	return nil
+}
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	addr, err := netip.ParseAddr(hop)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+func PrefixKey(fn KeyFunc, ipv4Bits, ipv6Bits int) (KeyFunc, error) {
+	if ipv4Bits < 0 || ipv4Bits > 32 || ipv6Bits < 0 || ipv6Bits > 128 {
+		return nil, fmt.Errorf("ratelimit: ip prefixes must be within 0-32 and 0-128, got /%d and /%d", ipv4Bits, ipv6Bits)
+	}
+	return func(r *http.Request) (string, error) {
+		key, err := fn(r)
+		if err != nil {
+			return "", err
+		}
+		addr, err := netip.ParseAddr(key)
+		if err != nil {
+			return key, nil
+		}
+		addr = addr.Unmap()
+		bits := ipv6Bits
+		if addr.Is4() {
+			bits = ipv4Bits
+		}
+		if bits >= addr.BitLen() {
+			return addr.String(), nil
+		}
+		prefix, err := addr.WithZone("").Prefix(bits)
+		if err != nil {
+			return "", err
+		}
+		return prefix.String(), nil
+	}, nil
+}
+func Header(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		if value := strings.TrimSpace(r.Header.Get(name)); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("%w: missing %s header", ErrNoKey, name)
+	}
+}
+func BearerToken(r *http.Request) (string, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fmt.Errorf("%w: missing bearer token", ErrNoKey)
+	}
+	return strings.TrimSpace(token), nil
+}
+func Cookie(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", fmt.Errorf("%w: missing %s cookie", ErrNoKey, name)
+		}
+		return cookie.Value, nil
+	}
+}
+func PathParam(pattern, name string) KeyFunc {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	return func(r *http.Request) (string, error) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		for i, segment := range segments {
+			if i >= len(parts) || parts[i] == "" {
+				break
+			}
+			if segment == "{"+name+"}" {
+				return parts[i], nil
+			}
+			if !strings.HasPrefix(segment, "{") && segment != parts[i] {
+				break
+			}
+		}
+		return "", fmt.Errorf("%w: path %q has no %s parameter", ErrNoKey, r.URL.Path, name)
+	}
+}
+func Composite(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		parts := make([]string, len(fns))
+		for i, fn := range fns {
+			part, err := fn(r)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = compositeEscape.Replace(part)
+		}
+		return strings.Join(parts, "|"), nil
+	}
+}
+func FirstOf(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		err := ErrNoKey
+		for _, fn := range fns {
+			var key string
+			if key, err = fn(r); err == nil {
+				return key, nil
+			}
+		}
+		return "", err
+	}
+}
+func Fallback(fn KeyFunc, key string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		if k, err := fn(r); err == nil {
+			return k, nil
+		}
+		return key, nil
+	}
+}
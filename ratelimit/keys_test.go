@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ClientIP(trusted...)
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"trusted peer uses forwarded for", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"skips trusted hops from the right", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"forwarded header wins", "[2001:db8::1]:443", map[string]string{"Forwarded": `for="[2001:db8::7]:80";proto=https`, "X-Forwarded-For": "198.51.100.1"}, "2001:db8::7"},
+		{"real ip", "10.0.0.2:4000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"garbage hop stops the walk", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, unknown"}, "10.0.0.2"},
+		{"mapped address", "[::ffff:203.0.113.9]:80", nil, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		got, err := key(req)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: expected %q, got %q (%v)", tt.name, tt.want, got, err)
+		}
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected invalid CIDR to be rejected")
+	}
+}
+func TestKeyFuncs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/tenants/acme/orders", nil)
+	req.Header.Set("X-API-Key", "k1")
+	req.Header.Set("Authorization", "Bearer t0k3n")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	tests := []struct {
+		name string
+		key  KeyFunc
+		want string
+	}{
+		{"header", Header("X-API-Key"), "k1"},
+		{"bearer", BearerToken, "t0k3n"},
+		{"cookie", Cookie("session"), "s1"},
+		{"path", PathParam("/tenants/{tenant}/orders", "tenant"), "acme"},
+		{"composite", Composite(PathParam("/tenants/{tenant}", "tenant"), Header("X-API-Key")), "acme|k1"},
+		{"first of", FirstOf(Header("X-Missing"), Cookie("session")), "s1"},
+		{"fallback", Fallback(Header("X-Missing"), "anonymous"), "anonymous"},
+	}
+	for _, tt := range tests {
+		got, err := tt.key(req)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: expected %q, got %q (%v)", tt.name, tt.want, got, err)
+		}
+	}
+	for name, key := range map[string]KeyFunc{
+		"header":    Header("X-Missing"),
+		"cookie":    Cookie("missing"),
+		"path":      PathParam("/users/{id}", "id"),
+		"composite": Composite(Header("X-API-Key"), Header("X-Missing")),
+		"first of":  FirstOf(Header("X-Missing"), Cookie("missing")),
+	} {
+		if _, err := key(req); !errors.Is(err, ErrNoKey) {
+			t.Errorf("%s: expected ErrNoKey, got %v", name, err)
+		}
+	}
+	composite := Composite(Header("X-A"), Header("X-B"))
+	seen := make(map[string]bool)
+	for _, pair := range [][2]string{{"a|b", "c"}, {"a", "b|c"}, {`a\`, "|c"}, {"a", `\|c`}} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-A", pair[0])
+		r.Header.Set("X-B", pair[1])
+		key, _ := composite(r)
+		if seen[key] {
+			t.Errorf("expected %q to get its own composite key, got %q", pair, key)
+		}
+		seen[key] = true
+	}
+	req.RemoteAddr = "invalid"
+	if _, err := RemoteIP(req); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey for invalid remote address, got %v", err)
+	}
+}
+func TestPrefixKey(t *testing.T) {
+	tests := []struct {
+		remote   string
+		v4, v6   int
+		expected string
+	}{
+		{"[2001:db8:1:2:3:4:5:6]:80", 32, 64, "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:3:4:5:6]:80", 32, 56, "2001:db8:1::/56"},
+		{"[2001:db8:1:2:3:4:5:6]:80", 32, 48, "2001:db8:1::/48"},
+		{"[2001:db8:1:2:3:4:5:6]:80", 32, 128, "2001:db8:1:2:3:4:5:6"},
+		{"[2001:db8:1:2:3:4:5:6]:80", 32, 0, "::/0"},
+		{"203.0.113.9:80", 0, 64, "0.0.0.0/0"},
+		{"203.0.113.9:80", 32, 64, "203.0.113.9"},
+		{"203.0.113.9:80", 24, 64, "203.0.113.0/24"},
+		{"[::ffff:203.0.113.9]:80", 24, 64, "203.0.113.0/24"},
+		{"[fe80::1%eth0]:80", 32, 64, "fe80::/64"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		fn, err := PrefixKey(RemoteIP, tt.v4, tt.v6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := fn(req)
+		if err != nil || got != tt.expected {
+			t.Errorf("%s with /%d and /%d: expected %q, got %q (%v)", tt.remote, tt.v4, tt.v6, tt.expected, got, err)
+		}
+	}
+	fn, _ := PrefixKey(Fallback(Header("X-Missing"), "unknown"), 24, 64)
+	if got, _ := fn(httptest.NewRequest(http.MethodGet, "/", nil)); got != "unknown" {
+		t.Errorf("expected non-address keys to pass through, got %q", got)
+	}
+	for _, bits := range [][2]int{{-1, 64}, {33, 64}, {24, -1}, {24, 129}} {
+		if _, err := PrefixKey(RemoteIP, bits[0], bits[1]); err == nil {
+			t.Errorf("expected /%d and /%d to be rejected", bits[0], bits[1])
+		}
+	}
+}
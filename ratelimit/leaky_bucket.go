@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type LeakyBucket struct {
+	capacity     int
+	water        float64
+	leakRate     Rate
+	lastLeakTime time.Time
+	mutex        sync.Mutex
+	metrics      *Metrics
+	clock        Clock
+}
+
+func NewLeakyBucket(capacity int, leakRate time.Duration, metrics *Metrics, opts ...Option) *LeakyBucket {
+	return NewLeakyBucketRate(capacity, Every(leakRate), metrics, opts...)
+}
+func NewLeakyBucketRate(capacity int, leakRate Rate, metrics *Metrics, opts ...Option) *LeakyBucket {
+	o := buildOptions(opts)
+	return &LeakyBucket{
+		capacity:     capacity,
+		water:        0,
+		leakRate:     leakRate,
+		lastLeakTime: o.clock.Now(),
+		metrics:      metrics,
+		clock:        o.clock,
+	}
+}
+func (b *LeakyBucket) leak(now time.Time) {
+	if now.After(b.lastLeakTime) {
+		b.water = max(b.water-b.leakRate.tokensFor(now.Sub(b.lastLeakTime)), 0)
+		b.lastLeakTime = now
+	}
+}
+func (b *LeakyBucket) delay(n int) time.Duration {
+	if n < 0 || n > b.capacity {
+		return InfDuration
+	}
+	if b.water+float64(n) <= float64(b.capacity)+tokenEpsilon {
+		return 0
+	}
+	return b.leakRate.durationFor(b.water + float64(n) - float64(b.capacity))
+}
+func (b *LeakyBucket) Allow() bool {
+	return b.AllowN(1).Allowed
+}
+func (b *LeakyBucket) AllowN(n int) Decision {
+	start := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.clock.Now()
+	b.leak(now)
+	d := Decision{Limit: b.capacity, Window: b.leakRate.durationFor(float64(b.capacity))}
+	if d.RetryAfter = b.delay(n); d.RetryAfter == 0 {
+		b.water += float64(n)
+		d.Allowed = true
+	}
+	d.Remaining = max(int(float64(b.capacity)-b.water+tokenEpsilon), 0)
+	d.ResetAt = b.emptyAt(now)
+	b.metrics.record(d.Allowed, start)
+	return d
+}
+func (b *LeakyBucket) emptyAt(now time.Time) time.Time {
+	return now.Add(b.leakRate.durationFor(b.water))
+}
+func (b *LeakyBucket) Reserve(n int) *Reservation {
+	return b.reserveN(b.clock.Now(), n, InfDuration)
+}
+func (b *LeakyBucket) Wait(ctx context.Context, n int) error {
+	return waitN(ctx, b.clock, b.metrics, b.reserveN, n)
+}
+func (b *LeakyBucket) reserveN(now time.Time, n int, maxWait time.Duration) *Reservation {
+	start := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.leak(now)
+	r := &Reservation{limit: b.capacity, tokens: n, clock: b.clock}
+	if wait := b.delay(n); wait <= maxWait && wait != InfDuration {
+		b.water += float64(n)
+		r.ok = true
+		r.timeToAct = now.Add(wait)
+		r.refund = func(now time.Time) { b.cancel(r, now) }
+	}
+	b.metrics.record(r.ok, start)
+	return r
+}
+func (b *LeakyBucket) cancel(r *Reservation, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if r.canceled || now.After(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+	b.leak(now)
+	b.water = max(b.water-float64(r.tokens), 0)
+}
+func (b *LeakyBucket) level() (string, float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.leak(b.clock.Now())
+	return "ratelimit_water_level", b.water
+}
+func (b *LeakyBucket) reconfigure(cfg Config) bool {
+	if cfg.Algorithm != AlgorithmLeakyBucket {
+		return false
+	}
+	b.SetCapacity(cfg.Limit)
+	b.SetRate(cfg.TokenRate())
+	return true
+}
+func (b *LeakyBucket) SetRate(rate Rate) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.leak(b.clock.Now())
+	b.leakRate = rate
+}
+func (b *LeakyBucket) SetCapacity(capacity int) error {
+	if capacity <= 0 {
+		return ErrInvalidLimit
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.leak(b.clock.Now())
+	b.water = b.water * float64(capacity) / float64(b.capacity)
+	b.capacity = capacity
+	return nil
+}
+func (b *LeakyBucket) credit(n int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.leak(b.clock.Now())
+	b.water = max(b.water-float64(n), 0)
+}
+func (b *LeakyBucket) state() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.leak(b.clock.Now())
+	return State{Limit: b.capacity, Rate: b.leakRate, Gauge: "water", Level: b.water}
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeakyBucket_Allow(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	bucket := NewLeakyBucket(2, 100*time.Millisecond, metrics, WithClock(clock))
+	if !bucket.Allow() {
+		t.Fatal("expected to allow the first request")
+	}
+	if !bucket.Allow() {
+		t.Fatal("expected to allow the second request")
+	}
+	if bucket.Allow() {
+		t.Fatal("expected to reject the third request")
+	}
+	clock.Advance(150 * time.Millisecond)
+	if !bucket.Allow() {
+		t.Fatal("expected to allow a request after the bucket has leaked")
+	}
+	if metrics.Allowed != 3 || metrics.Rejected != 1 {
+		t.Errorf("expected 3 processed and 1 discarded, got %d and %d", metrics.Allowed, metrics.Rejected)
+	}
+}
+func TestLeakyBucketLeak(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	bucket := NewLeakyBucket(1, 100*time.Millisecond, metrics, WithClock(clock))
+	if !bucket.Allow() {
+		t.Fatal("expected to allow the first request")
+	}
+	clock.Advance(200 * time.Millisecond)
+	if !bucket.Allow() {
+		t.Fatal("expected to allow a request after the bucket has leaked")
+	}
+}
+func TestLeakyBucket_Decision(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	bucket := NewLeakyBucket(3, 100*time.Millisecond, nil, WithClock(clock))
+	bucket.AllowN(3)
+	clock.Advance(50 * time.Millisecond)
+	d := bucket.AllowN(2)
+	if d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected a full bucket to reject, got %+v", d)
+	}
+	if d.RetryAfter != 150*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 150ms", d.RetryAfter)
+	}
+	if !d.ResetAt.Equal(start.Add(300 * time.Millisecond)) {
+		t.Errorf("ResetAt = %v, want %v", d.ResetAt, start.Add(300*time.Millisecond))
+	}
+}
+func TestLeakyBucket_AllowN(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewLeakyBucket(5, 100*time.Millisecond, nil, WithClock(clock))
+	if d := bucket.AllowN(4); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("unexpected decision for AllowN(4): %+v", d)
+	}
+	if bucket.AllowN(2).Allowed {
+		t.Fatal("expected AllowN(2) to overflow the bucket")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !bucket.AllowN(2).Allowed {
+		t.Error("expected AllowN(2) to fit after one unit leaked")
+	}
+}
+func TestLeakyBucket_SubTickLeak(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewLeakyBucketRate(1, 10, nil, WithClock(clock))
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if bucket.Allow() {
+			allowed++
+		}
+		clock.Advance(40 * time.Millisecond)
+	}
+	if allowed != 34 {
+		t.Errorf("expected 34 requests over 4s polled every 40ms, got %d", allowed)
+	}
+}
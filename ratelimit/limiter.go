@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrExceedsLimit    = errors.New("ratelimit: request exceeds limiter capacity")
+	ErrExceedsDeadline = errors.New("ratelimit: wait would exceed context deadline")
+	ErrNegativeCount   = errors.New("ratelimit: token count must not be negative")
+	ErrInvalidLimit    = errors.New("ratelimit: limit must be positive")
+	ErrInvalidWindow   = errors.New("ratelimit: window must be positive")
+)
+
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration
+	Window     time.Duration
+}
+type Limiter interface {
+	Allow() bool
+	AllowN(n int) Decision
+	Reserve(n int) *Reservation
+	Wait(ctx context.Context, n int) error
+}
+type Reservation struct {
+	ok        bool
+	limit     int
+	tokens    int
+	timeToAct time.Time
+	clock     Clock
+	refund    func(now time.Time)
+	canceled  bool
+}
+
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.clock.Now())
+}
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	return max(r.timeToAct.Sub(now), 0)
+}
+func (r *Reservation) Cancel() {
+	r.CancelAt(r.clock.Now())
+}
+func (r *Reservation) CancelAt(now time.Time) {
+	if !r.ok || r.refund == nil {
+		return
+	}
+	r.refund(now)
+}
+
+const InfDuration = time.Duration(1<<63 - 1)
+const pollInterval = 10 * time.Millisecond
+
+type reserveFunc func(now time.Time, n int, maxWait time.Duration) *Reservation
+
+func waitN(ctx context.Context, clock Clock, metrics *Metrics, reserve reserveFunc, n int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	now := clock.Now()
+	maxWait := InfDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(now)
+	}
+	r := reserve(now, n, maxWait)
+	if !r.ok {
+		if n < 0 {
+			return ErrNegativeCount
+		}
+		if n > r.limit {
+			return ErrExceedsLimit
+		}
+		return ErrExceedsDeadline
+	}
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		metrics.observeWait(0)
+		return nil
+	}
+	timer := clock.NewTimer(delay)
+	select {
+	case <-timer.C():
+		metrics.observeWait(delay)
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+func reserve(l Limiter, clock Clock, n int) *Reservation {
+	d := l.AllowN(n)
+	return &Reservation{ok: d.Allowed, limit: d.Limit, tokens: n, timeToAct: clock.Now(), clock: clock}
+}
+func wait(ctx context.Context, clock Clock, metrics *Metrics, l Limiter, n int) error {
+	start := clock.Now()
+	for {
+		d := l.AllowN(n)
+		if d.Allowed {
+			metrics.observeWait(clock.Now().Sub(start))
+			return nil
+		}
+		if n < 0 {
+			return ErrNegativeCount
+		}
+		if n > d.Limit {
+			return ErrExceedsLimit
+		}
+		delay := d.RetryAfter
+		if delay <= 0 {
+			delay = pollInterval
+		}
+		timer := clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(1, 20*time.Millisecond, nil, WithClock(clock))
+	bucket.Allow()
+	done := make(chan error, 1)
+	go func() {
+		done <- bucket.Wait(context.Background(), 1)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(20 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("Wait returned error: %v", err)
+	}
+	if err := bucket.Wait(context.Background(), 2); err != ErrExceedsLimit {
+		t.Errorf("expected ErrExceedsLimit, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- bucket.Wait(ctx, 1)
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+func TestTokenBucket_Reserve(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(2, time.Second, nil, WithClock(clock))
+	if r := bucket.Reserve(2); !r.OK() || r.Delay() != 0 {
+		t.Fatalf("expected an immediate reservation, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	r := bucket.Reserve(1)
+	if !r.OK() || r.Delay() != time.Second {
+		t.Fatalf("expected a reservation one second out, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	if next := bucket.Reserve(1); next.Delay() != 2*time.Second {
+		t.Errorf("expected a queued reservation two seconds out, got %v", next.Delay())
+	} else {
+		next.Cancel()
+	}
+	r.Cancel()
+	r.Cancel()
+	if again := bucket.Reserve(1); again.Delay() != time.Second {
+		t.Errorf("expected cancelled tokens to be refunded, got delay %v", again.Delay())
+	}
+	if r := bucket.Reserve(3); r.OK() || r.Delay() != InfDuration {
+		t.Errorf("expected a reservation larger than capacity to fail")
+	}
+}
+func TestWait_Deadline(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(1, time.Minute, nil, WithClock(clock))
+	bucket.Allow()
+	ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Second))
+	defer cancel()
+	if err := bucket.Wait(ctx, 1); err != ErrExceedsDeadline {
+		t.Fatalf("expected ErrExceedsDeadline, got %v", err)
+	}
+	clock.Advance(time.Minute)
+	if !bucket.Allow() {
+		t.Error("expected a failed Wait not to consume tokens")
+	}
+}
+func TestWait_CancelRefunds(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewLeakyBucket(1, time.Second, nil, WithClock(clock))
+	bucket.Allow()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- bucket.Wait(ctx, 1)
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	clock.Advance(time.Second)
+	if !bucket.Allow() {
+		t.Error("expected the cancelled Wait to release its place in the bucket")
+	}
+}
+func TestLeakyBucket_Reserve(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewLeakyBucket(2, 100*time.Millisecond, nil, WithClock(clock))
+	bucket.AllowN(2)
+	first := bucket.Reserve(1)
+	second := bucket.Reserve(1)
+	if first.Delay() != 100*time.Millisecond || second.Delay() != 200*time.Millisecond {
+		t.Fatalf("expected queued reservations at 100ms and 200ms, got %v and %v", first.Delay(), second.Delay())
+	}
+	if bucket.Allow() {
+		t.Error("expected queued reservations to block immediate requests")
+	}
+}
+func TestFixedWindowCounter_Reserve(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	counter, _ := NewFixedWindowCounter(10, time.Minute, nil, WithClock(clock))
+	counter.AllowN(8)
+	clock.Advance(20 * time.Second)
+	r := counter.Reserve(5)
+	if !r.OK() || r.Delay() != 40*time.Second {
+		t.Fatalf("expected the reservation to move to the next window, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	if later := counter.Reserve(6); later.Delay() != 100*time.Second {
+		t.Errorf("expected a reservation that does not fit the next window to go one further, got %v", later.Delay())
+	} else {
+		later.Cancel()
+	}
+	clock.Advance(40 * time.Second)
+	if d := counter.AllowN(5); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected the next window to hold the reserved 5 plus 5 more, got %+v", d)
+	}
+	if counter.Allow() {
+		t.Error("expected the window to be full")
+	}
+}
+func TestNegativeCount(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	for _, cfg := range []Config{
+		{Algorithm: AlgorithmTokenBucket, Limit: 2, Rate: time.Minute},
+		{Algorithm: AlgorithmGCRA, Limit: 2, Rate: time.Minute},
+		{Algorithm: AlgorithmLeakyBucket, Limit: 2, Rate: time.Minute},
+		{Algorithm: AlgorithmFixedWindowCounter, Limit: 2, Window: time.Minute},
+		{Algorithm: AlgorithmSlidingWindowLog, Limit: 2, Window: time.Minute},
+		{Algorithm: AlgorithmSlidingWindowCounter, Limit: 2, Window: time.Minute},
+	} {
+		l, _ := New(cfg, nil, WithClock(clock))
+		if d := l.AllowN(-5); d.Allowed || d.RetryAfter != InfDuration {
+			t.Errorf("expected %s to reject a negative count, got %+v", cfg.Algorithm, d)
+		}
+		if r := l.Reserve(-5); r.OK() {
+			t.Errorf("expected %s to refuse a negative reservation", cfg.Algorithm)
+		}
+		if err := l.Wait(context.Background(), -5); !errors.Is(err, ErrNegativeCount) {
+			t.Errorf("expected %s to fail a negative wait, got %v", cfg.Algorithm, err)
+		}
+		if !l.AllowN(2).Allowed || l.Allow() {
+			t.Errorf("expected %s to keep its capacity after negative counts", cfg.Algorithm)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const histogramBuckets = 13
+
+var (
+	latencyBuckets = [histogramBuckets]float64{.000001, .0000025, .000005, .00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01}
+	waitBuckets    = [histogramBuckets]float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+)
+
+type Metrics struct {
+	Allowed  int
+	Rejected int
+	Mutex    sync.Mutex
+	allowed  atomic.Int64
+	rejected atomic.Int64
+	latency  histogram
+	waits    histogram
+}
+type histogram struct {
+	counts [histogramBuckets]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64
+}
+
+func (h *histogram) observe(bounds *[histogramBuckets]float64, v float64) {
+	for i, bound := range bounds {
+		if v <= bound {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+func (h *histogram) total() float64 {
+	return math.Float64frombits(h.sum.Load())
+}
+func (m *Metrics) Counts() (allowed, rejected int) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	return m.Allowed + int(m.allowed.Load()), m.Rejected + int(m.rejected.Load())
+}
+func (m *Metrics) record(allowed bool, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.latency.observe(&latencyBuckets, time.Since(start).Seconds())
+	m.Mutex.Lock()
+	if allowed {
+		m.Allowed++
+	} else {
+		m.Rejected++
+	}
+	m.Mutex.Unlock()
+}
+func (m *Metrics) recordAtomic(allowed bool, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.latency.observe(&latencyBuckets, time.Since(start).Seconds())
+	if allowed {
+		m.allowed.Add(1)
+	} else {
+		m.rejected.Add(1)
+	}
+}
+func (m *Metrics) observeWait(delay time.Duration) {
+	if m == nil {
+		return
+	}
+	m.waits.observe(&waitBuckets, delay.Seconds())
+}
+func MetricsHandler(metrics *Metrics) http.HandlerFunc {
+	return ExpositionHandler(Source{Metrics: metrics})
+}
+func writePlain(w http.ResponseWriter, sources []Source) {
+	s := snapshot(sources)
+	fmt.Fprintf(w, "Total requests: %d\n", s.Allowed)
+	fmt.Fprintf(w, "Rejected requests: %d\n", s.Rejected)
+	for _, stats := range s.Limiters {
+		if stats.Name == "" {
+			continue
+		}
+		name := stats.Name
+		if stats.Route != "" {
+			name += " (" + stats.Route + ")"
+		}
+		fmt.Fprintf(w, "%s total requests: %d\n", name, stats.Allowed)
+		fmt.Fprintf(w, "%s rejected requests: %d\n", name, stats.Rejected)
+	}
+}
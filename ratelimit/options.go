@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type options struct {
+	clock        Clock
+	headers      HeaderStyle
+	cost         func(*http.Request) int
+	reject       http.HandlerFunc
+	shards       int
+	maxKeys      int
+	full         FullPolicy
+	ctx          context.Context
+	timeout      time.Duration
+	replicas     int
+	breakerTrips int
+	breakerCool  time.Duration
+}
+type Option func(*options)
+
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+func WithHeaders(style HeaderStyle) Option {
+	return func(o *options) {
+		o.headers = style
+	}
+}
+func WithCost(cost func(*http.Request) int) Option {
+	return func(o *options) {
+		o.cost = cost
+	}
+}
+func WithRejectHandler(reject http.HandlerFunc) Option {
+	return func(o *options) {
+		o.reject = reject
+	}
+}
+func WithShards(shards int) Option {
+	return func(o *options) {
+		o.shards = shards
+	}
+}
+func WithMaxKeys(maxKeys int, policy FullPolicy) Option {
+	return func(o *options) {
+		o.maxKeys = maxKeys
+		o.full = policy
+	}
+}
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+func WithReplicas(replicas int) Option {
+	return func(o *options) {
+		o.replicas = replicas
+	}
+}
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.breakerTrips = failures
+		o.breakerCool = cooldown
+	}
+}
+func buildOptions(opts []Option) options {
+	o := options{
+		clock:        RealClock{},
+		headers:      DefaultHeaders,
+		cost:         unitCost,
+		reject:       rejectTooManyRequests,
+		shards:       defaultShards,
+		ctx:          context.Background(),
+		timeout:      defaultBackendTimeout,
+		replicas:     1,
+		breakerTrips: defaultBreakerTrips,
+		breakerCool:  defaultBreakerCool,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+func unitCost(*http.Request) int {
+	return 1
+}
@@ -0,0 +1,535 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultTTL = 3 * time.Minute
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m\", got %s", b)
+	}
+	return d.parse(s)
+}
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+func (d *Duration) parse(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type Rate float64
+
+var rateUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	return r.parse(s)
+}
+func (r *Rate) UnmarshalYAML(node *yaml.Node) error {
+	return r.parse(node.Value)
+}
+func (r *Rate) parse(s string) error {
+	count, unit, found := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return fmt.Errorf("invalid rate %q: expected a number of events such as 10/s", s)
+	}
+	per := time.Second
+	if found {
+		if per = rateUnits[strings.TrimSpace(unit)]; per == 0 {
+			if per, err = time.ParseDuration(strings.TrimSpace(unit)); err != nil || per <= 0 {
+				return fmt.Errorf("invalid rate %q: unknown unit %q", s, unit)
+			}
+		}
+	}
+	*r = Rate(n / per.Seconds())
+	return nil
+}
+
+type Override struct {
+	Key    string   `json:"key" yaml:"key"`
+	Rate   Rate     `json:"rate" yaml:"rate"`
+	Burst  int      `json:"burst" yaml:"burst"`
+	Limit  int      `json:"limit" yaml:"limit"`
+	Window Duration `json:"window" yaml:"window"`
+}
+type Rule struct {
+	Name      string              `json:"name" yaml:"name"`
+	Path      string              `json:"path" yaml:"path"`
+	Methods   []string            `json:"methods" yaml:"methods"`
+	Key       string              `json:"key" yaml:"key"`
+	Fallback  string              `json:"fallback" yaml:"fallback"`
+	Algorithm ratelimit.Algorithm `json:"algorithm" yaml:"algorithm"`
+	Rate      Rate                `json:"rate" yaml:"rate"`
+	Burst     int                 `json:"burst" yaml:"burst"`
+	Limit     int                 `json:"limit" yaml:"limit"`
+	Window    Duration            `json:"window" yaml:"window"`
+	Buckets   int                 `json:"buckets" yaml:"buckets"`
+	TTL       Duration            `json:"ttl" yaml:"ttl"`
+	MaxKeys   int                 `json:"max_keys" yaml:"max_keys"`
+	Overrides []Override          `json:"overrides" yaml:"overrides"`
+}
+type Policy struct {
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+	IPv4Prefix     *int     `json:"ipv4_prefix" yaml:"ipv4_prefix"`
+	IPv6Prefix     *int     `json:"ipv6_prefix" yaml:"ipv6_prefix"`
+	Rules          []Rule   `json:"rules" yaml:"rules"`
+}
+
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+func Parse(data []byte, format string) (*Policy, error) {
+	p := &Policy{}
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(p); err != nil {
+			return nil, err
+		}
+	case "yaml", "yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(p); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported policy format %q", format)
+	}
+	return p, p.Validate()
+}
+func (p *Policy) Validate() error {
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("policy has no rules")
+	}
+	if _, err := ratelimit.ParseTrustedProxies(p.TrustedProxies); err != nil {
+		return err
+	}
+	ipv4Bits, ipv6Bits := p.prefixes()
+	if _, err := ratelimit.PrefixKey(ratelimit.RemoteIP, ipv4Bits, ipv6Bits); err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s#%d", rule.Path, i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		if _, err := p.keyFunc(*rule); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+func (r *Rule) validate() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path must start with /, got %q", r.Path)
+	}
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(method)
+	}
+	if _, err := r.config(r.Rate, r.Burst, r.Limit, r.Window); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, o := range r.Overrides {
+		if o.Key == "" || seen[o.Key] {
+			return fmt.Errorf("override keys must be unique and non-empty, got %q", o.Key)
+		}
+		seen[o.Key] = true
+		if _, err := r.override(o); err != nil {
+			return fmt.Errorf("override %q: %w", o.Key, err)
+		}
+	}
+	return nil
+}
+func (r Rule) config(rate Rate, burst, limit int, window Duration) (ratelimit.Config, error) {
+	cfg := ratelimit.Config{Algorithm: r.Algorithm, Buckets: r.Buckets}
+	switch r.Algorithm {
+	case ratelimit.AlgorithmTokenBucket, ratelimit.AlgorithmLeakyBucket, ratelimit.AlgorithmGCRA:
+		if rate <= 0 || burst <= 0 {
+			return cfg, fmt.Errorf("%s needs a positive rate and burst", r.Algorithm)
+		}
+		cfg.Limit, cfg.PerSecond = burst, float64(rate)
+	case ratelimit.AlgorithmFixedWindowCounter, ratelimit.AlgorithmSlidingWindowLog, ratelimit.AlgorithmSlidingWindowCounter:
+		if limit <= 0 || window <= 0 {
+			return cfg, fmt.Errorf("%s needs a positive limit and window", r.Algorithm)
+		}
+		cfg.Limit, cfg.Window = limit, time.Duration(window)
+	default:
+		return cfg, fmt.Errorf("unknown algorithm %q", r.Algorithm)
+	}
+	return cfg, nil
+}
+func (r Rule) override(o Override) (ratelimit.Config, error) {
+	rate, burst, limit, window := r.Rate, r.Burst, r.Limit, r.Window
+	if o.Rate > 0 {
+		rate = o.Rate
+	}
+	if o.Burst > 0 {
+		burst = o.Burst
+	}
+	if o.Limit > 0 {
+		limit = o.Limit
+	}
+	if o.Window > 0 {
+		window = o.Window
+	}
+	return r.config(rate, burst, limit, window)
+}
+func (r Rule) matches(req *http.Request) bool {
+	if !matchPath(r.Path, req.URL.Path) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, method := range r.Methods {
+		if method == req.Method {
+			return true
+		}
+	}
+	return false
+}
+func matchPath(template, path string) bool {
+	if template == "/" {
+		return true
+	}
+	subtree := strings.HasSuffix(template, "/")
+	if !subtree && strings.HasSuffix(path, "/") {
+		return false
+	}
+	want := strings.Split(strings.Trim(template, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(got) < len(want) || (!subtree && len(got) != len(want)) {
+		return false
+	}
+	if subtree && len(got) == len(want) && !strings.HasSuffix(path, "/") {
+		return false
+	}
+	for i, segment := range want {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if got[i] == "" {
+				return false
+			}
+		} else if segment != got[i] {
+			return false
+		}
+	}
+	return true
+}
+func (p *Policy) keyFunc(rule Rule) (ratelimit.KeyFunc, error) {
+	spec := rule.Key
+	if spec == "" {
+		spec = "ip"
+	}
+	var alternatives []ratelimit.KeyFunc
+	for _, alternative := range strings.Split(spec, "|") {
+		var parts []ratelimit.KeyFunc
+		for _, part := range strings.Split(alternative, "+") {
+			fn, err := p.extractor(rule, strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, fn)
+		}
+		fn := parts[0]
+		if len(parts) > 1 {
+			fn = ratelimit.Composite(parts...)
+		}
+		alternatives = append(alternatives, fn)
+	}
+	fn := alternatives[0]
+	if len(alternatives) > 1 {
+		fn = ratelimit.FirstOf(alternatives...)
+	}
+	if rule.Fallback != "" {
+		fn = ratelimit.Fallback(fn, rule.Fallback)
+	}
+	return fn, nil
+}
+func (p *Policy) prefixes() (int, int) {
+	ipv4Bits, ipv6Bits := 32, 128
+	if p.IPv4Prefix != nil {
+		ipv4Bits = *p.IPv4Prefix
+	}
+	if p.IPv6Prefix != nil {
+		ipv6Bits = *p.IPv6Prefix
+	}
+	return ipv4Bits, ipv6Bits
+}
+func (p *Policy) extractor(rule Rule, spec string) (ratelimit.KeyFunc, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch {
+	case kind == "global" && arg == "":
+		return func(*http.Request) (string, error) { return "global", nil }, nil
+	case kind == "ip" && arg == "":
+		trusted, err := ratelimit.ParseTrustedProxies(p.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		ipv4Bits, ipv6Bits := p.prefixes()
+		return ratelimit.PrefixKey(ratelimit.ClientIP(trusted...), ipv4Bits, ipv6Bits)
+	case kind == "remote" && arg == "":
+		return ratelimit.RemoteIP, nil
+	case kind == "bearer" && arg == "":
+		return ratelimit.BearerToken, nil
+	case kind == "header" && arg != "":
+		return ratelimit.Header(arg), nil
+	case kind == "cookie" && arg != "":
+		return ratelimit.Cookie(arg), nil
+	case kind == "param" && arg != "":
+		if !strings.Contains(rule.Path, "{"+arg+"}") {
+			return nil, fmt.Errorf("path %q has no {%s} parameter", rule.Path, arg)
+		}
+		return ratelimit.PathParam(rule.Path, arg), nil
+	}
+	return nil, fmt.Errorf("invalid key %q", spec)
+}
+
+type Chain struct {
+	next  http.Handler
+	opts  []ratelimit.Option
+	mutex sync.Mutex
+	state atomic.Pointer[chainState]
+}
+type chainState struct {
+	handler  http.Handler
+	registry *ratelimit.Registry
+	rules    map[string]*ruleState
+}
+type ruleState struct {
+	rule      Rule
+	base      ratelimit.Config
+	overrides map[string]ratelimit.Config
+	store     *ratelimit.KeyedLimiter
+	metrics   *ratelimit.Metrics
+	opts      []ratelimit.Option
+}
+
+func (p *Policy) Build(next http.Handler, opts ...ratelimit.Option) (*Chain, error) {
+	chain := &Chain{next: next, opts: opts}
+	if err := chain.Reload(p); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+func (c *Chain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.state.Load().handler.ServeHTTP(w, r)
+}
+func (c *Chain) Registry() *ratelimit.Registry {
+	return c.state.Load().registry
+}
+func (c *Chain) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Registry().ServeHTTP(w, r)
+	})
+}
+func (c *Chain) ReloadFile(path string) error {
+	p, err := Load(path)
+	if err != nil {
+		return err
+	}
+	return c.Reload(p)
+}
+func (c *Chain) Reload(p *Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	rules := make([]*ruleState, len(p.Rules))
+	keys := make([]ratelimit.KeyFunc, len(p.Rules))
+	for i, rule := range p.Rules {
+		rs, err := newRuleState(rule, c.opts)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		if keys[i], err = p.keyFunc(rule); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		rules[i] = rs
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	old := c.state.Load()
+	state := &chainState{registry: ratelimit.NewRegistry(), rules: make(map[string]*ruleState)}
+	var reused []*ruleState
+	for _, rs := range rules {
+		if previous := old.rule(rs.rule.Name); previous != nil && previous.rule.TTL == rs.rule.TTL && previous.rule.MaxKeys == rs.rule.MaxKeys {
+			rs.store, rs.metrics = previous.store, previous.metrics
+			reused = append(reused, rs)
+		} else {
+			rs.metrics = &ratelimit.Metrics{}
+			rs.store = ratelimit.NewKeyedLimiter(rs.limiter, rs.ttl(), rs.storeOptions()...)
+			if previous != nil {
+				if a := previous.store.Adjustment(); a != (ratelimit.Adjustment{}) {
+					rs.store.Adjust(a)
+				}
+			}
+		}
+		state.rules[rs.rule.Name] = rs
+		state.registry.Register(ratelimit.Source{Name: rs.rule.Name, Route: rs.rule.Path, Metrics: rs.metrics, Keyed: rs.store})
+	}
+	for _, rs := range reused {
+		rs.store.Reconfigure(rs.limiter, rs.update)
+	}
+	state.handler = c.handler(rules, keys)
+	c.state.Store(state)
+	if old != nil {
+		for name, rs := range old.rules {
+			if state.rules[name] == nil || state.rules[name].store != rs.store {
+				rs.store.Close()
+			}
+		}
+	}
+	return nil
+}
+func (c *Chain) handler(rules []*ruleState, keys []ratelimit.KeyFunc) http.Handler {
+	stages := make([]ratelimit.KeyedRule, len(rules))
+	for i, rs := range rules {
+		stages[i] = ratelimit.KeyedRule{Limiter: rs.store, Key: keys[i], Match: rs.rule.matches}
+	}
+	return ratelimit.KeyedRulesHandler(stages, c.next.ServeHTTP, c.opts...)
+}
+func (c *Chain) Watch(ctx context.Context, path string, interval time.Duration, signals <-chan os.Signal, clock ratelimit.Clock, report func(error)) {
+	last := version(path)
+	for {
+		var timer ratelimit.Timer
+		var tick <-chan time.Time
+		if interval > 0 {
+			timer = clock.NewTimer(interval)
+			tick = timer.C()
+		}
+		reload := false
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-signals:
+			if timer != nil {
+				timer.Stop()
+			}
+			reload = true
+		case <-tick:
+		}
+		if current := version(path); current != last {
+			last, reload = current, true
+		}
+		if reload {
+			report(c.ReloadFile(path))
+		}
+	}
+}
+func (c *Chain) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, rs := range c.state.Load().rules {
+		rs.store.Close()
+	}
+	return nil
+}
+func (s *chainState) rule(name string) *ruleState {
+	if s == nil {
+		return nil
+	}
+	return s.rules[name]
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func version(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}
+}
+func newRuleState(rule Rule, opts []ratelimit.Option) (*ruleState, error) {
+	base, err := rule.config(rule.Rate, rule.Burst, rule.Limit, rule.Window)
+	if err != nil {
+		return nil, err
+	}
+	rs := &ruleState{rule: rule, base: base, overrides: make(map[string]ratelimit.Config), opts: opts}
+	for _, o := range rule.Overrides {
+		if rs.overrides[o.Key], err = rule.override(o); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+func (rs *ruleState) config(key string) ratelimit.Config {
+	if cfg, found := rs.overrides[key]; found {
+		return cfg
+	}
+	return rs.base
+}
+func (rs *ruleState) limiter(key string) ratelimit.Limiter {
+	l, _ := ratelimit.New(rs.config(key), rs.metrics, rs.opts...)
+	return l
+}
+func (rs *ruleState) update(key string, l ratelimit.Limiter) ratelimit.Limiter {
+	if next, err := ratelimit.Reconfigure(l, rs.config(key), rs.metrics, rs.opts...); err == nil {
+		return next
+	}
+	return l
+}
+func (rs *ruleState) ttl() time.Duration {
+	if rs.rule.TTL <= 0 {
+		return defaultTTL
+	}
+	return time.Duration(rs.rule.TTL)
+}
+func (rs *ruleState) storeOptions() []ratelimit.Option {
+	if rs.rule.MaxKeys <= 0 {
+		return rs.opts
+	}
+	return append([]ratelimit.Option{ratelimit.WithMaxKeys(rs.rule.MaxKeys, ratelimit.EvictOldest)}, rs.opts...)
+}
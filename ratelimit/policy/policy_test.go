@@ -0,0 +1,373 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+const testPolicy = `
+trusted_proxies: [10.0.0.0/8]
+ipv6_prefix: 64
+rules:
+  - name: writes
+    path: /api/
+    methods: [post, put]
+    key: ip
+    algorithm: token-bucket
+    rate: 1/m
+    burst: 1
+  - name: api
+    path: /api/
+    key: bearer | ip
+    algorithm: fixed-window-counter
+    limit: 3
+    window: 1m
+    overrides:
+      - key: premium
+        limit: 5
+  - name: users
+    path: /users/{id}/
+    key: param:id
+    fallback: anonymous
+    algorithm: sliding-window-counter
+    limit: 1
+    window: 1h
+    buckets: 4
+`
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+func serve(h http.Handler, method, target string, header ...string) int {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(testPolicy), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 3 || p.Rules[0].Methods[0] != http.MethodPost || p.Rules[0].Rate != Rate(1.0/60) {
+		t.Errorf("unexpected policy: %+v", p.Rules[0])
+	}
+	if time.Duration(p.Rules[1].Window) != time.Minute || p.Rules[1].Overrides[0].Limit != 5 {
+		t.Errorf("unexpected rule: %+v", p.Rules[1])
+	}
+	data := `{"rules": [{"path": "/", "algorithm": "gcra", "rate": "100/s", "burst": 10}]}`
+	if p, err = Parse([]byte(data), ".json"); err != nil || p.Rules[0].Rate != 100 || p.Rules[0].Name != "/#0" {
+		t.Errorf("expected a valid JSON policy, got %+v, %v", p, err)
+	}
+	data = `{"ipv4_prefix": 0, "rules": [{"path": "/", "algorithm": "gcra", "rate": "100/s", "burst": 10}]}`
+	if p, err = Parse([]byte(data), ".json"); err != nil || p.IPv4Prefix == nil || *p.IPv4Prefix != 0 || p.IPv6Prefix != nil {
+		t.Errorf("expected /0 to be kept apart from an unset prefix, got %+v, %v", p, err)
+	}
+}
+func TestParse_Invalid(t *testing.T) {
+	for _, data := range []string{
+		`rules: []`,
+		`rules: [{path: /, algorithm: token-bucket, rate: 1/s, burst: 1, typo: true}]`,
+		`rules: [{path: api, algorithm: token-bucket, rate: 1/s, burst: 1}]`,
+		`rules: [{path: /, algorithm: token-bucket, rate: 1/fortnight, burst: 1}]`,
+		`rules: [{path: /, algorithm: token-bucket, rate: 1/s}]`,
+		`rules: [{path: /, algorithm: fixed-window-counter, limit: 1}]`,
+		`rules: [{path: /, algorithm: gcra-ish, rate: 1/s, burst: 1}]`,
+		`rules: [{path: /, key: header, algorithm: gcra, rate: 1/s, burst: 1}]`,
+		`rules: [{path: /, key: param:id, algorithm: gcra, rate: 1/s, burst: 1}]`,
+		`rules: [{name: a, path: /, algorithm: gcra, rate: 1/s, burst: 1}, {name: a, path: /b, algorithm: gcra, rate: 1/s, burst: 1}]`,
+		`rules: [{path: /, algorithm: gcra, rate: 1/s, burst: 1, overrides: [{key: a}, {key: a}]}]`,
+		`{trusted_proxies: [not-a-cidr], rules: [{path: /, algorithm: gcra, rate: 1/s, burst: 1}]}`,
+		`{ipv4_prefix: -1, rules: [{path: /, algorithm: gcra, rate: 1/s, burst: 1}]}`,
+		`{ipv6_prefix: 129, rules: [{path: /, algorithm: gcra, rate: 1/s, burst: 1}]}`,
+	} {
+		if _, err := Parse([]byte(data), "yml"); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+	if _, err := Parse([]byte(`{"rules": [{"path": "/", "window": 60}]}`), "json"); err == nil {
+		t.Error("expected an error for a numeric JSON window")
+	}
+	if _, err := Parse([]byte(testPolicy), "toml"); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := Load(path); err != nil || len(p.Rules) != 3 {
+		t.Errorf("expected the policy to load, got %+v, %v", p, err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+func TestBuild(t *testing.T) {
+	p, err := Parse([]byte(testPolicy), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := ratelimit.NewManualClock(time.Now())
+	chain, err := p.Build(okHandler(), ratelimit.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	h := chain
+	if code := serve(h, http.MethodPost, "/api/items", "X-Forwarded-For", "203.0.113.9"); code != http.StatusOK {
+		t.Fatalf("expected the first write to be allowed, got %d", code)
+	}
+	if code := serve(h, http.MethodPost, "/api/items", "X-Forwarded-For", "203.0.113.9"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the second write to be limited, got %d", code)
+	}
+	if code := serve(h, http.MethodPost, "/api/items", "X-Forwarded-For", "203.0.113.10"); code != http.StatusOK {
+		t.Errorf("expected another client to have its own budget, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := serve(h, http.MethodGet, "/api/items", "Authorization", "Bearer basic"); code != http.StatusOK {
+			t.Fatalf("expected read %d to be allowed, got %d", i+1, code)
+		}
+	}
+	if code := serve(h, http.MethodGet, "/api/items", "Authorization", "Bearer basic"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the api limit to apply per bearer token, got %d", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := serve(h, http.MethodGet, "/api/items", "Authorization", "Bearer premium"); code != http.StatusOK {
+			t.Fatalf("expected premium request %d to be allowed, got %d", i+1, code)
+		}
+	}
+	if code := serve(h, http.MethodGet, "/api/items", "Authorization", "Bearer premium"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the premium override to be enforced, got %d", code)
+	}
+	if serve(h, http.MethodGet, "/users/42/profile") != http.StatusOK || serve(h, http.MethodGet, "/users/42/profile") != http.StatusTooManyRequests {
+		t.Error("expected the users rule to limit per path parameter")
+	}
+	if code := serve(h, http.MethodGet, "/users/7/profile"); code != http.StatusOK {
+		t.Errorf("expected another user to have its own budget, got %d", code)
+	}
+	if code := serve(h, http.MethodGet, "/health"); code != http.StatusOK {
+		t.Errorf("expected unmatched paths to reach the next handler, got %d", code)
+	}
+	snapshot := chain.Registry().Snapshot()
+	if len(snapshot.Limiters) != 3 || snapshot.Limiters[0].Name != "writes" || snapshot.Rejected != 4 {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+	rec := httptest.NewRecorder()
+	chain.Registry().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	if !strings.Contains(rec.Body.String(), `ratelimit_tracked_keys{limiter="users",route="/users/{id}/"} 2`) {
+		t.Errorf("expected keyed metrics per rule, got:\n%s", rec.Body.String())
+	}
+}
+func TestBuild_Paths(t *testing.T) {
+	p, err := Parse([]byte(`
+rules:
+  - name: global
+    path: /
+    key: global
+    algorithm: fixed-window-counter
+    limit: 9
+    window: 1h
+  - name: user
+    path: /users/{id}
+    key: param:id
+    algorithm: fixed-window-counter
+    limit: 100
+    window: 1h
+  - name: orders
+    path: /users/{id}/orders
+    key: param:id
+    algorithm: fixed-window-counter
+    limit: 1
+    window: 1h
+  - name: admin
+    path: /users/{id}/admin/
+    key: param:id
+    algorithm: fixed-window-counter
+    limit: 1
+    window: 1h
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := p.Build(okHandler(), ratelimit.WithClock(ratelimit.NewManualClock(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	for _, tt := range []struct {
+		target string
+		code   int
+	}{
+		{"/users/1", http.StatusOK},
+		{"/users/1", http.StatusOK},
+		{"/users/1/profile", http.StatusOK},
+		{"/users/1/orders", http.StatusOK},
+		{"/users/1/orders", http.StatusTooManyRequests},
+		{"/users/2/orders", http.StatusOK},
+		{"/users/1/orders/", http.StatusOK},
+		{"/users/1/admin/settings", http.StatusOK},
+		{"/users/1/admin", http.StatusOK},
+		{"/users/1/admin/", http.StatusTooManyRequests},
+		{"/health", http.StatusOK},
+		{"/health", http.StatusTooManyRequests},
+	} {
+		if code := serve(chain, http.MethodGet, tt.target); code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.target, code, tt.code)
+		}
+	}
+	var counts []string
+	for _, l := range chain.Registry().Snapshot().Limiters {
+		counts = append(counts, fmt.Sprintf("%s=%d/%d", l.Name, l.Allowed, l.Rejected))
+	}
+	if got := strings.Join(counts, " "); got != "global=11/1 user=2/0 orders=2/1 admin=1/1" {
+		t.Errorf("expected every matching rule to apply in file order, got %s", got)
+	}
+}
+func TestReload(t *testing.T) {
+	p, err := Parse([]byte(testPolicy), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := ratelimit.NewManualClock(time.Now())
+	chain, err := p.Build(okHandler(), ratelimit.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	for i := 0; i < 2; i++ {
+		serve(chain, http.MethodGet, "/api/items", "Authorization", "Bearer basic")
+	}
+	serve(chain, http.MethodGet, "/users/42/")
+	users := chain.Registry().Sources()[2].Keyed
+	updated := strings.Replace(testPolicy, "limit: 3\n", "limit: 6\n", 1)
+	updated = strings.Replace(updated, "  - name: users", "  - name: accounts", 1)
+	if p, err = Parse([]byte(updated), "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Reload(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if code := serve(chain, http.MethodGet, "/api/items", "Authorization", "Bearer basic"); code != http.StatusOK {
+			t.Fatalf("expected request %d to fit the raised limit, got %d", i+3, code)
+		}
+	}
+	if code := serve(chain, http.MethodGet, "/api/items", "Authorization", "Bearer basic"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the earlier requests to still count against the new limit, got %d", code)
+	}
+	if snapshot := chain.Registry().Snapshot(); snapshot.Limiters[1].Allowed != 6 || snapshot.Limiters[2].Name != "accounts" || snapshot.Limiters[2].Allowed != 0 {
+		t.Errorf("expected metrics to carry over, got %+v", snapshot)
+	}
+	select {
+	case <-time.After(time.Second):
+		t.Error("expected the store of a removed rule to be closed")
+	case <-closed(users):
+	}
+	if err := chain.Reload(&Policy{}); err == nil {
+		t.Error("expected an invalid policy to be rejected")
+	}
+	if code := serve(chain, http.MethodGet, "/api/items", "Authorization", "Bearer basic"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a failed reload to keep the current policy, got %d", code)
+	}
+}
+func TestReload_Adjustments(t *testing.T) {
+	p, err := Parse([]byte(testPolicy), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := p.Build(okHandler(), ratelimit.WithClock(ratelimit.NewManualClock(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	capacity := 1
+	for _, source := range chain.Registry().Sources()[1:] {
+		if err := source.Keyed.Adjust(ratelimit.Adjustment{Capacity: &capacity}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated := strings.Replace(testPolicy, "    buckets: 4\n", "    buckets: 4\n    ttl: 2h\n", 1)
+	if p, err = Parse([]byte(updated), "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Reload(p); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/api/items", "/users/42/"} {
+		if serve(chain, http.MethodGet, target) != http.StatusOK || serve(chain, http.MethodGet, target) != http.StatusTooManyRequests {
+			t.Errorf("expected the admin capacity to survive the reload on %s", target)
+		}
+	}
+}
+func closed(store *ratelimit.KeyedLimiter) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		store.Close()
+		close(done)
+	}()
+	return done
+}
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(limit int) {
+		data := fmt.Sprintf(`{"rules": [{"path": "/", "key": "global", "algorithm": "fixed-window-counter", "limit": %d, "window": "1h"}]}`, limit)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1)
+	p, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := ratelimit.NewManualClock(time.Now())
+	chain, err := p.Build(okHandler(), ratelimit.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal)
+	reports := make(chan error)
+	stopped := make(chan struct{})
+	go func() {
+		chain.Watch(ctx, path, time.Second, signals, clock, func(err error) { reports <- err })
+		close(stopped)
+	}()
+	serve(chain, http.MethodGet, "/")
+	clock.BlockUntil(2)
+	write(3)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	clock.Advance(time.Second)
+	if err := <-reports; err != nil {
+		t.Fatal(err)
+	}
+	if serve(chain, http.MethodGet, "/") != http.StatusOK || serve(chain, http.MethodGet, "/") != http.StatusOK || serve(chain, http.MethodGet, "/") != http.StatusTooManyRequests {
+		t.Error("expected a file change to raise the limit without resetting the count")
+	}
+	os.WriteFile(path, []byte("{"), 0o600)
+	signals <- syscall.SIGHUP
+	if err := <-reports; err == nil {
+		t.Error("expected a broken file to be reported")
+	}
+	cancel()
+	<-stopped
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+type Rate float64
+
+const tokenEpsilon = 1e-9
+
+func Every(interval time.Duration) Rate {
+	if interval <= 0 {
+		return Rate(math.Inf(1))
+	}
+	return Rate(float64(time.Second) / float64(interval))
+}
+func (r Rate) tokensFor(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return d.Seconds() * float64(r)
+}
+func (r Rate) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if r <= 0 {
+		return InfDuration
+	}
+	ns := math.Ceil(tokens / float64(r) * float64(time.Second))
+	if ns >= math.MaxInt64 {
+		return InfDuration
+	}
+	return time.Duration(ns)
+}
@@ -0,0 +1,232 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+const scriptPrelude = `
+if redis.replicate_commands then redis.replicate_commands() end
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+`
+
+var (
+	tokenBucketScript = newScript(scriptPrelude + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate / 1000000)
+	ts = now
+end
+local allowed, retry = 0, 0
+if n <= tokens + 1e-9 then
+	tokens = tokens - n
+	allowed = 1
+elseif n > capacity then
+	retry = -1
+else
+	retry = math.ceil((n - tokens) * 1000000 / rate)
+end
+local reset = math.ceil((capacity - tokens) * 1000000 / rate)
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.17g', tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000) + 1000)
+return {allowed, math.floor(tokens + 1e-9), retry, reset}
+`)
+	fixedWindowScript = newScript(scriptPrelude + `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+local count = tonumber(state[1]) or 0
+local start = tonumber(state[2]) or now
+if now >= start + window then
+	count = 0
+	start = now
+end
+local allowed, retry = 0, 0
+if count + n <= limit then
+	count = count + n
+	allowed = 1
+elseif n > limit then
+	retry = -1
+else
+	retry = start + window - now
+end
+redis.call('HSET', KEYS[1], 'count', count, 'start', start)
+redis.call('PEXPIRE', KEYS[1], math.ceil((start + window - now) / 1000) + 1000)
+return {allowed, math.max(limit - count, 0), retry, start + window - now}
+`)
+	slidingLogScript = newScript(scriptPrelude + `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed, retry = 0, 0
+if count + n <= limit then
+	local seq = redis.call('INCRBY', KEYS[2], n) - n
+	for i = 1, n do
+		redis.call('ZADD', KEYS[1], now, string.format('%.0f:%020d', now, seq + i))
+	end
+	count = count + n
+	allowed = 1
+elseif n > limit then
+	retry = -1
+else
+	local entry = redis.call('ZRANGE', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+	retry = tonumber(entry[2]) + window - now
+end
+local reset = 0
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000) + 1000)
+redis.call('PEXPIRE', KEYS[2], math.ceil(window / 1000) + 1000)
+return {allowed, limit - count, retry, reset}
+`)
+	tokenBucketRefundScript = newScript(scriptPrelude + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+if not state[1] then
+	return 0
+end
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if now > ts then
+	tokens = tokens + (now - ts) * rate / 1000000
+	ts = now
+end
+tokens = math.min(capacity, tokens + n)
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.17g', tokens), 'ts', ts)
+return 1
+`)
+	fixedWindowRefundScript = newScript(scriptPrelude + `
+local window = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local state = redis.call('HMGET', KEYS[1], 'count', 'start')
+if not state[1] or now >= tonumber(state[2]) + window then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'count', math.max(tonumber(state[1]) - n, 0))
+return 1
+`)
+	slidingLogRefundScript = newScript(scriptPrelude + `
+redis.call('ZPOPMAX', KEYS[1], tonumber(ARGV[1]))
+return 1
+`)
+)
+
+type script struct {
+	src string
+	sha string
+}
+
+func newScript(src string) *script {
+	sum := sha1.Sum([]byte(src))
+	return &script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+func (s *script) run(ctx context.Context, c *Client, keys []string, args ...string) (any, error) {
+	cmd := append([]string{"EVALSHA", s.sha, strconv.Itoa(len(keys))}, keys...)
+	reply, err := c.Do(ctx, append(cmd, args...)...)
+	var redisErr Error
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		return c.Do(ctx, append(cmd, args...)...)
+	}
+	return reply, err
+}
+
+type Backend struct {
+	client *Client
+	prefix string
+	clock  ratelimit.Clock
+}
+
+func NewBackend(client *Client, prefix string, clock ratelimit.Clock) *Backend {
+	if clock == nil {
+		clock = ratelimit.RealClock{}
+	}
+	return &Backend{client: client, prefix: prefix, clock: clock}
+}
+func (b *Backend) Take(ctx context.Context, key string, cfg ratelimit.Config, n int) (ratelimit.Decision, error) {
+	d := ratelimit.Decision{Limit: cfg.Limit, Window: cfg.Window}
+	keys := []string{b.key(key, cfg)}
+	var s *script
+	var args []string
+	switch cfg.Algorithm {
+	case ratelimit.AlgorithmTokenBucket:
+		s = tokenBucketScript
+		rate := cfg.TokenRate()
+		args = []string{strconv.Itoa(cfg.Limit), strconv.FormatFloat(float64(rate), 'g', -1, 64), strconv.Itoa(n)}
+		d.Window = time.Duration(float64(cfg.Limit) / float64(rate) * float64(time.Second))
+	case ratelimit.AlgorithmFixedWindowCounter:
+		s = fixedWindowScript
+		args = []string{strconv.Itoa(cfg.Limit), strconv.FormatInt(cfg.Window.Microseconds(), 10), strconv.Itoa(n)}
+	case ratelimit.AlgorithmSlidingWindowLog:
+		s = slidingLogScript
+		args = []string{strconv.Itoa(cfg.Limit), strconv.FormatInt(cfg.Window.Microseconds(), 10), strconv.Itoa(n)}
+		keys = append(keys, keys[0]+":seq")
+	default:
+		return d, fmt.Errorf("redis: unsupported algorithm %q", cfg.Algorithm)
+	}
+	reply, err := s.run(ctx, b.client, keys, args...)
+	if err != nil {
+		return d, err
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) != 4 {
+		return d, fmt.Errorf("redis: unexpected script reply %v", reply)
+	}
+	ints := make([]int64, len(values))
+	for i, value := range values {
+		if ints[i], ok = value.(int64); !ok {
+			return d, fmt.Errorf("redis: unexpected script reply %v", reply)
+		}
+	}
+	d.Allowed = ints[0] == 1
+	d.Remaining = int(ints[1])
+	d.RetryAfter = time.Duration(ints[2]) * time.Microsecond
+	if ints[2] < 0 {
+		d.RetryAfter = ratelimit.InfDuration
+	}
+	d.ResetAt = b.clock.Now().Add(time.Duration(ints[3]) * time.Microsecond)
+	return d, nil
+}
+func (b *Backend) Refund(ctx context.Context, key string, cfg ratelimit.Config, n int) error {
+	var s *script
+	var args []string
+	switch cfg.Algorithm {
+	case ratelimit.AlgorithmTokenBucket:
+		s = tokenBucketRefundScript
+		args = []string{strconv.Itoa(cfg.Limit), strconv.FormatFloat(float64(cfg.TokenRate()), 'g', -1, 64), strconv.Itoa(n)}
+	case ratelimit.AlgorithmFixedWindowCounter:
+		s = fixedWindowRefundScript
+		args = []string{strconv.FormatInt(cfg.Window.Microseconds(), 10), strconv.Itoa(n)}
+	case ratelimit.AlgorithmSlidingWindowLog:
+		s = slidingLogRefundScript
+		args = []string{strconv.Itoa(n)}
+	default:
+		return fmt.Errorf("redis: unsupported algorithm %q", cfg.Algorithm)
+	}
+	_, err := s.run(ctx, b.client, []string{b.key(key, cfg)}, args...)
+	return err
+}
+func (b *Backend) key(key string, cfg ratelimit.Config) string {
+	return b.prefix + string(cfg.Algorithm) + ":" + key
+}
@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPoolSize    = 8
+	defaultDialTimeout = 5 * time.Second
+)
+
+var ErrNil = errors.New("redis: nil reply")
+
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+type Client struct {
+	addr        string
+	password    string
+	db          int
+	dialTimeout time.Duration
+	pool        chan *conn
+}
+type Option func(*Client)
+
+func WithPassword(password string) Option {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+func WithDB(db int) Option {
+	return func(c *Client) {
+		c.db = db
+	}
+}
+func WithPoolSize(size int) Option {
+	return func(c *Client) {
+		c.pool = make(chan *conn, max(size, 1))
+	}
+}
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = timeout
+	}
+}
+func NewClient(addr string, opts ...Option) *Client {
+	c := &Client{
+		addr:        addr,
+		dialTimeout: defaultDialTimeout,
+		pool:        make(chan *conn, defaultPoolSize),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+	dialer := net.Dialer{Timeout: c.dialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, reader: bufio.NewReader(nc), writer: bufio.NewWriter(nc)}
+	if c.password != "" {
+		if _, err := cn.do(ctx, "AUTH", c.password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+func (c *Client) put(cn *conn) {
+	select {
+	case c.pool <- cn:
+	default:
+		cn.Close()
+	}
+}
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(ctx, args...)
+	var redisErr Error
+	if err != nil && !errors.As(err, &redisErr) {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.pool:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+func (cn *conn) do(ctx context.Context, args ...string) (reply any, err error) {
+	deadline, _ := ctx.Deadline()
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		cn.SetDeadline(time.Unix(1, 0))
+	})
+	defer func() {
+		if !stop() && err != nil && ctx.Err() != nil {
+			reply, err = nil, ctx.Err()
+		}
+	}()
+	if err := writeCommand(cn.writer, args...); err != nil {
+		return nil, err
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+func writeCommand(w *bufio.Writer, args ...string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil || size < -1 {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if size == -1 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(body)
+		if err != nil || size < -1 {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if size == -1 {
+			return nil, ErrNil
+		}
+		values := make([]any, size)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil && !errors.Is(err, ErrNil) {
+				var redisErr Error
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				values[i] = redisErr
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
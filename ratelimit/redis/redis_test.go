@@ -0,0 +1,513 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdrcstcs/CV-RateLimiter/ratelimit"
+)
+
+// fakeRedis speaks enough RESP to exercise the client, and answers EVALSHA by
+// running Go ports of the Lua scripts keyed by their SHA. The Lua itself is not
+// executed here; TestBackend_Redis runs it against a real server when
+// REDIS_ADDR is set.
+type zentry struct {
+	score  float64
+	member string
+}
+type fakeRedis struct {
+	listener net.Listener
+	clock    ratelimit.Clock
+	password string
+	mutex    sync.Mutex
+	loaded   map[string]bool
+	hashes   map[string]map[string]string
+	zsets    map[string][]zentry
+	counters map[string]int64
+	calls    map[string]int
+}
+
+func newFakeRedis(t *testing.T, clock ratelimit.Clock, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		listener: ln,
+		clock:    clock,
+		password: password,
+		loaded:   make(map[string]bool),
+		hashes:   make(map[string]map[string]string),
+		zsets:    make(map[string][]zentry),
+		counters: make(map[string]int64),
+		calls:    make(map[string]int),
+	}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+func (f *fakeRedis) serve() {
+	for {
+		nc, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(nc)
+	}
+}
+func (f *fakeRedis) handle(nc net.Conn) {
+	defer nc.Close()
+	reader, writer := bufio.NewReader(nc), bufio.NewWriter(nc)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		values, _ := reply.([]any)
+		args := make([]string, len(values))
+		for i, value := range values {
+			args[i], _ = value.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		var result any
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = len(args) == 2 && args[1] == f.password
+			result = "OK"
+			if !authed {
+				result = Error("WRONGPASS invalid password")
+			}
+		case !authed:
+			result = Error("NOAUTH Authentication required.")
+		default:
+			result = f.exec(cmd, args[1:])
+		}
+		writeFakeReply(writer, result)
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+func writeFakeReply(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []int64:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, i := range v {
+			fmt.Fprintf(w, ":%d\r\n", i)
+		}
+	}
+}
+func (f *fakeRedis) exec(cmd string, args []string) any {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls[cmd]++
+	switch cmd {
+	case "PING":
+		return "PONG"
+	case "SELECT":
+		return "OK"
+	case "EVALSHA", "EVAL":
+		sha := args[0]
+		if cmd == "EVAL" {
+			sha = newScript(sha).sha
+			f.loaded[sha] = true
+		}
+		if !f.loaded[sha] {
+			return Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		numKeys, _ := strconv.Atoi(args[1])
+		keys, argv := args[2:2+numKeys], args[2+numKeys:]
+		now := float64(f.clock.Now().UnixMicro())
+		switch sha {
+		case tokenBucketScript.sha:
+			return f.tokenBucket(keys[0], now, argv)
+		case fixedWindowScript.sha:
+			return f.fixedWindow(keys[0], now, argv)
+		case slidingLogScript.sha:
+			return f.slidingLog(keys[0], keys[1], now, argv)
+		case tokenBucketRefundScript.sha, fixedWindowRefundScript.sha, slidingLogRefundScript.sha:
+			return f.refund(sha, keys[0], now, argv)
+		}
+		return Error("ERR unknown script")
+	}
+	return Error(fmt.Sprintf("ERR unknown command '%s'", cmd))
+}
+func numbers(argv []string) []float64 {
+	values := make([]float64, len(argv))
+	for i, arg := range argv {
+		values[i], _ = strconv.ParseFloat(arg, 64)
+	}
+	return values
+}
+func (f *fakeRedis) hash(key string) map[string]string {
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string]string)
+	}
+	return f.hashes[key]
+}
+func field(h map[string]string, name string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(h[name], 64); err == nil {
+		return value
+	}
+	return fallback
+}
+func (f *fakeRedis) tokenBucket(key string, now float64, argv []string) []int64 {
+	a := numbers(argv)
+	capacity, rate, n := a[0], a[1], a[2]
+	h := f.hash(key)
+	tokens, ts := field(h, "tokens", capacity), field(h, "ts", now)
+	if now > ts {
+		tokens = math.Min(capacity, tokens+(now-ts)*rate/1e6)
+		ts = now
+	}
+	allowed, retry := 0.0, 0.0
+	if n <= tokens+1e-9 {
+		tokens -= n
+		allowed = 1
+	} else if n > capacity {
+		retry = -1
+	} else {
+		retry = math.Ceil((n - tokens) * 1e6 / rate)
+	}
+	reset := math.Ceil((capacity - tokens) * 1e6 / rate)
+	h["tokens"], h["ts"] = strconv.FormatFloat(tokens, 'g', 17, 64), strconv.FormatFloat(ts, 'f', 0, 64)
+	return []int64{int64(allowed), int64(math.Floor(tokens + 1e-9)), int64(retry), int64(reset)}
+}
+func (f *fakeRedis) fixedWindow(key string, now float64, argv []string) []int64 {
+	a := numbers(argv)
+	limit, window, n := a[0], a[1], a[2]
+	h := f.hash(key)
+	count, start := field(h, "count", 0), field(h, "start", now)
+	if now >= start+window {
+		count, start = 0, now
+	}
+	allowed, retry := 0.0, 0.0
+	if count+n <= limit {
+		count += n
+		allowed = 1
+	} else if n > limit {
+		retry = -1
+	} else {
+		retry = start + window - now
+	}
+	h["count"], h["start"] = strconv.FormatFloat(count, 'f', 0, 64), strconv.FormatFloat(start, 'f', 0, 64)
+	return []int64{int64(allowed), int64(math.Max(limit-count, 0)), int64(retry), int64(start + window - now)}
+}
+func (f *fakeRedis) slidingLog(key, seq string, now float64, argv []string) []int64 {
+	a := numbers(argv)
+	limit, window, n := a[0], a[1], a[2]
+	var entries []zentry
+	for _, e := range f.zsets[key] {
+		if e.score > now-window {
+			entries = append(entries, e)
+		}
+	}
+	count := float64(len(entries))
+	allowed, retry := 0.0, 0.0
+	if count+n <= limit {
+		for i := 0; i < int(n); i++ {
+			f.counters[seq]++
+			entries = append(entries, zentry{score: now, member: fmt.Sprintf("%.0f:%020d", now, f.counters[seq])})
+		}
+		count += n
+		allowed = 1
+	} else if n > limit {
+		retry = -1
+	} else {
+		retry = entries[int(count+n-limit-1)].score + window - now
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score < entries[j].score
+		}
+		return entries[i].member < entries[j].member
+	})
+	f.zsets[key] = entries
+	reset := 0.0
+	if len(entries) > 0 {
+		reset = entries[0].score + window - now
+	}
+	return []int64{int64(allowed), int64(limit - count), int64(retry), int64(reset)}
+}
+func (f *fakeRedis) refund(sha, key string, now float64, argv []string) int64 {
+	a := numbers(argv)
+	h := f.hashes[key]
+	switch {
+	case sha == slidingLogRefundScript.sha:
+		entries := f.zsets[key]
+		f.zsets[key] = entries[:len(entries)-min(int(a[0]), len(entries))]
+	case h == nil:
+		return 0
+	case sha == tokenBucketRefundScript.sha:
+		tokens, ts := field(h, "tokens", 0), field(h, "ts", now)
+		tokens = math.Min(a[0], tokens+(now-ts)*a[1]/1e6+a[2])
+		h["tokens"], h["ts"] = strconv.FormatFloat(tokens, 'g', 17, 64), strconv.FormatFloat(math.Max(now, ts), 'f', 0, 64)
+	case now >= field(h, "start", 0)+a[0]:
+		return 0
+	default:
+		h["count"] = strconv.FormatFloat(math.Max(field(h, "count", 0)-a[1], 0), 'f', 0, 64)
+	}
+	return 1
+}
+func (f *fakeRedis) count(cmd string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[cmd]
+}
+func TestClient_Do(t *testing.T) {
+	fake := newFakeRedis(t, ratelimit.RealClock{}, "hunter2")
+	client := NewClient(fake.addr(), WithPassword("hunter2"), WithDB(2), WithPoolSize(1))
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		if reply, err := client.Do(context.Background(), "PING"); err != nil || reply != "PONG" {
+			t.Fatalf("expected PONG, got %v, %v", reply, err)
+		}
+	}
+	if fake.count("SELECT") != 1 {
+		t.Errorf("expected pooled connections to be reused, got %d dials", fake.count("SELECT"))
+	}
+	var redisErr Error
+	if _, err := client.Do(context.Background(), "FLUSHALL"); !errors.As(err, &redisErr) {
+		t.Errorf("expected a server error, got %v", err)
+	}
+	if _, err := NewClient(fake.addr(), WithPassword("wrong")).Do(context.Background(), "PING"); err == nil {
+		t.Error("expected a wrong password to be refused")
+	}
+}
+func TestClient_Cancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if nc, err := ln.Accept(); err == nil {
+			accepted <- nc
+		}
+	}()
+	defer func() {
+		select {
+		case nc := <-accepted:
+			nc.Close()
+		default:
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewClient(ln.Addr().String()).Do(ctx, "PING")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the cancellation to be returned, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a cancelled context to interrupt a blocked read")
+	}
+}
+func TestReadReply(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("*4\r\n$3\r\nfoo\r\n$-1\r\n:7\r\n-ERR bad\r\n$5\r\nhello\r\n%1\r\n"))
+	reply, err := readReply(reader)
+	if err != nil || fmt.Sprint(reply) != "[foo <nil> 7 ERR bad]" {
+		t.Errorf("unexpected array reply %v, %v", reply, err)
+	}
+	if reply, err := readReply(reader); err != nil || reply != "hello" {
+		t.Errorf("unexpected bulk reply %v, %v", reply, err)
+	}
+	if _, err := readReply(reader); err == nil {
+		t.Error("expected an unknown reply type to fail")
+	}
+}
+func TestBackend_TokenBucket(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	fake := newFakeRedis(t, clock, "")
+	client := NewClient(fake.addr())
+	defer client.Close()
+	backend := NewBackend(client, "rl:", clock)
+	cfg := ratelimit.Config{Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 3, Rate: time.Second}
+	replicas := make([]*ratelimit.DistributedLimiter, 2)
+	for i := range replicas {
+		l, err := ratelimit.NewDistributedLimiter(backend, "client-1", cfg, nil, ratelimit.WithClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas[i] = l
+	}
+	for i := 0; i < 3; i++ {
+		if !replicas[i%2].Allow() {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+	d := replicas[1].AllowN(1)
+	if d.Allowed || d.RetryAfter != time.Second || d.Remaining != 0 || d.Limit != 3 {
+		t.Errorf("expected replicas to share one budget, got %+v", d)
+	}
+	if fake.count("EVALSHA") != 4 || fake.count("EVAL") != 1 {
+		t.Errorf("expected one EVAL fallback followed by EVALSHA, got %d and %d", fake.count("EVALSHA"), fake.count("EVAL"))
+	}
+	clock.Advance(time.Second)
+	if !replicas[0].Allow() || replicas[1].Allow() {
+		t.Error("expected the bucket to refill using server time")
+	}
+	if d := replicas[0].AllowN(4); d.Allowed || d.RetryAfter != ratelimit.InfDuration {
+		t.Errorf("expected a request above capacity to never fit, got %+v", d)
+	}
+	if err := backend.Refund(context.Background(), "client-1", cfg, 1); err != nil || !replicas[1].Allow() {
+		t.Errorf("expected a refunded token to be shared, got %v", err)
+	}
+}
+func TestBackend_Windows(t *testing.T) {
+	clock := ratelimit.NewManualClock(time.Now())
+	fake := newFakeRedis(t, clock, "")
+	backend := NewBackend(NewClient(fake.addr()), "rl:", clock)
+	ctx := context.Background()
+	fixed := ratelimit.Config{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 2, Window: time.Minute}
+	backend.Take(ctx, "a", fixed, 2)
+	clock.Advance(20 * time.Second)
+	if d, err := backend.Take(ctx, "a", fixed, 1); err != nil || d.Allowed || d.RetryAfter != 40*time.Second {
+		t.Errorf("expected the fixed window to be full for 40s, got %+v, %v", d, err)
+	}
+	clock.Advance(40 * time.Second)
+	if d, _ := backend.Take(ctx, "a", fixed, 2); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected a new window, got %+v", d)
+	}
+	if err := backend.Refund(ctx, "a", fixed, 2); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := backend.Take(ctx, "a", fixed, 2); !d.Allowed {
+		t.Errorf("expected refunded window slots to be reusable, got %+v", d)
+	}
+	log := ratelimit.Config{Algorithm: ratelimit.AlgorithmSlidingWindowLog, Limit: 3, Window: time.Minute}
+	backend.Take(ctx, "a", log, 2)
+	clock.Advance(30 * time.Second)
+	backend.Take(ctx, "a", log, 1)
+	if d, _ := backend.Take(ctx, "a", log, 2); d.Allowed || d.RetryAfter != 30*time.Second {
+		t.Errorf("expected to wait for the two oldest entries to expire, got %+v", d)
+	}
+	clock.Advance(30 * time.Second)
+	if d, _ := backend.Take(ctx, "a", log, 2); !d.Allowed || d.Remaining != 0 || !d.ResetAt.Equal(clock.Now().Add(30*time.Second)) {
+		t.Errorf("expected the oldest entries to have expired, got %+v", d)
+	}
+	if err := backend.Refund(ctx, "a", log, 1); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := backend.Take(ctx, "a", log, 1); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected a refunded log entry to be reusable, got %+v", d)
+	}
+	burst := ratelimit.Config{Algorithm: ratelimit.AlgorithmSlidingWindowLog, Limit: 20, Window: time.Minute}
+	backend.Take(ctx, "b", burst, 12)
+	backend.Refund(ctx, "b", burst, 1)
+	backend.Take(ctx, "b", burst, 1)
+	fake.mutex.Lock()
+	entries := fake.zsets[backend.key("b", burst)]
+	fake.mutex.Unlock()
+	if last := entries[len(entries)-1].member; len(entries) != 12 || !strings.HasSuffix(last, ":00000000000000000013") {
+		t.Errorf("expected entries from the same instant to get unique members in insertion order, got %d ending in %s", len(entries), last)
+	}
+	if _, err := backend.Take(ctx, "a", ratelimit.Config{Algorithm: ratelimit.AlgorithmLeakyBucket, Limit: 1, Rate: time.Second}, 1); err == nil {
+		t.Error("expected an unsupported algorithm to fail")
+	}
+}
+func TestDistributedLimiter_Unavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	metrics := &ratelimit.Metrics{}
+	l, _ := ratelimit.NewDistributedLimiter(NewBackend(NewClient(ln.Addr().String()), "rl:", nil), "a", ratelimit.Config{Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 1, Rate: time.Second}, metrics)
+	if _, err := l.Take(context.Background(), 1); err == nil {
+		t.Error("expected an unreachable server to fail")
+	}
+	if l.Allow() {
+		t.Error("expected the limiter to deny requests while the backend is down")
+	}
+	if _, rejected := metrics.Counts(); rejected != 1 {
+		t.Errorf("expected the failure to be counted as a rejection, got %d", rejected)
+	}
+}
+func TestBackend_Redis(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("set REDIS_ADDR to run the scripts against a real Redis server")
+	}
+	client := NewClient(addr)
+	defer client.Close()
+	prefix := fmt.Sprintf("ratelimit-test:%d:", time.Now().UnixNano())
+	backend := NewBackend(client, prefix, nil)
+	ctx := context.Background()
+	defer func() {
+		reply, _ := client.Do(ctx, "KEYS", prefix+"*")
+		keys, _ := reply.([]any)
+		for _, key := range keys {
+			client.Do(ctx, "DEL", fmt.Sprint(key))
+		}
+	}()
+	for _, cfg := range []ratelimit.Config{
+		{Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 3, Rate: time.Hour},
+		{Algorithm: ratelimit.AlgorithmFixedWindowCounter, Limit: 3, Window: time.Hour},
+		{Algorithm: ratelimit.AlgorithmSlidingWindowLog, Limit: 3, Window: time.Hour},
+	} {
+		for i := 0; i < 3; i++ {
+			if d, err := backend.Take(ctx, "a", cfg, 1); err != nil || !d.Allowed || d.Remaining != 2-i {
+				t.Fatalf("%s: expected request %d to be allowed, got %+v, %v", cfg.Algorithm, i+1, d, err)
+			}
+		}
+		if d, _ := backend.Take(ctx, "a", cfg, 1); d.Allowed || d.RetryAfter <= 0 || d.RetryAfter > time.Hour {
+			t.Errorf("%s: expected a rejection retryable within the hour, got %+v", cfg.Algorithm, d)
+		}
+		if d, _ := backend.Take(ctx, "a", cfg, 4); d.RetryAfter != ratelimit.InfDuration {
+			t.Errorf("%s: expected a request above the limit to never fit, got %+v", cfg.Algorithm, d)
+		}
+		if err := backend.Refund(ctx, "a", cfg, 1); err != nil {
+			t.Fatal(err)
+		}
+		if d, _ := backend.Take(ctx, "a", cfg, 1); !d.Allowed {
+			t.Errorf("%s: expected a refunded token to be reusable, got %+v", cfg.Algorithm, d)
+		}
+		if d, _ := backend.Take(ctx, "a", cfg, 1); d.Allowed {
+			t.Errorf("%s: expected the budget to be spent again, got %+v", cfg.Algorithm, d)
+		}
+	}
+	log := ratelimit.Config{Algorithm: ratelimit.AlgorithmSlidingWindowLog, Limit: 100, Window: time.Hour}
+	for i := 0; i < 10; i++ {
+		backend.Take(ctx, "b", log, 5)
+	}
+	reply, err := client.Do(ctx, "ZRANGE", backend.key("b", log), "0", "-1", "WITHSCORES")
+	entries, _ := reply.([]any)
+	if err != nil || len(entries) != 100 {
+		t.Fatalf("expected 50 distinct log entries, got %d, %v", len(entries)/2, err)
+	}
+	for i := 0; i < len(entries); i += 2 {
+		if member, score := entries[i].(string), entries[i+1].(string); !strings.HasPrefix(member, score+":") {
+			t.Errorf("expected member %q to start with its exact score %s", member, score)
+		}
+	}
+}
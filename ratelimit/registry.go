@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+type LimiterStats struct {
+	Name     string        `json:"name"`
+	Route    string        `json:"route,omitempty"`
+	Allowed  int           `json:"allowed"`
+	Rejected int           `json:"rejected"`
+	Gauge    string        `json:"gauge,omitempty"`
+	Level    float64       `json:"level"`
+	Keys     *KeyedStats   `json:"keys,omitempty"`
+	Backend  *BackendStats `json:"backend,omitempty"`
+}
+type MetricsSnapshot struct {
+	Allowed  int            `json:"allowed"`
+	Rejected int            `json:"rejected"`
+	Limiters []LimiterStats `json:"limiters"`
+}
+type Registry struct {
+	mutex   sync.Mutex
+	sources []Source
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+func (reg *Registry) Register(source Source) error {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	if source.Name == "" {
+		return fmt.Errorf("ratelimit: registered limiter needs a name")
+	}
+	for _, existing := range reg.sources {
+		if existing.Name == source.Name {
+			return fmt.Errorf("ratelimit: limiter %q is already registered", source.Name)
+		}
+	}
+	reg.sources = append(reg.sources, source)
+	return nil
+}
+func (reg *Registry) Sources() []Source {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	return append([]Source(nil), reg.sources...)
+}
+func (reg *Registry) Snapshot() MetricsSnapshot {
+	return snapshot(reg.Sources())
+}
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveMetrics(w, r, reg.Sources())
+}
+func snapshot(sources []Source) MetricsSnapshot {
+	s := MetricsSnapshot{Limiters: []LimiterStats{}}
+	seen := make(map[*Metrics]bool)
+	for _, source := range sources {
+		stats := LimiterStats{Name: source.Name, Route: source.Route}
+		if m := source.Metrics; m != nil {
+			stats.Allowed, stats.Rejected = m.Counts()
+			if !seen[m] {
+				seen[m] = true
+				s.Allowed += stats.Allowed
+				s.Rejected += stats.Rejected
+			}
+		}
+		if source.Keyed != nil {
+			keys := source.Keyed.Stats()
+			stats.Keys = &keys
+		}
+		if source.Backend != nil {
+			backend := source.Backend.Stats()
+			stats.Backend = &backend
+		}
+		if g, ok := source.Limiter.(gauge); ok {
+			stats.Gauge, stats.Level = g.level()
+		}
+		s.Limiters = append(s.Limiters, stats)
+	}
+	return s
+}
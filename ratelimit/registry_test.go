@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	registry := NewRegistry()
+	globalMetrics, adminMetrics := &Metrics{}, &Metrics{}
+	global := NewTokenBucket(3, time.Second, globalMetrics, WithClock(clock))
+	admin := NewTokenBucket(1, time.Second, adminMetrics, WithClock(clock))
+	if err := registry.Register(Source{Name: "global", Route: "/", Limiter: global, Metrics: globalMetrics}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(Source{Name: "admin", Route: "/admin", Limiter: admin, Metrics: adminMetrics}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(Source{Name: "admin"}); err == nil {
+		t.Error("expected duplicate name to be rejected")
+	}
+	if err := registry.Register(Source{}); err == nil {
+		t.Error("expected unnamed limiter to be rejected")
+	}
+	global.Allow()
+	for i := 0; i < 3; i++ {
+		admin.Allow()
+	}
+	rr := httptest.NewRecorder()
+	registry.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	expected := "Total requests: 2\nRejected requests: 2\n" +
+		"global (/) total requests: 1\nglobal (/) rejected requests: 0\n" +
+		"admin (/admin) total requests: 1\nadmin (/admin) rejected requests: 2\n"
+	if rr.Body.String() != expected {
+		t.Errorf("expected body %q, got %q", expected, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	registry.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics?format=json", nil))
+	var snapshot MetricsSnapshot
+	if err := json.NewDecoder(rr.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Allowed != 2 || snapshot.Rejected != 2 || len(snapshot.Limiters) != 2 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if admin := snapshot.Limiters[1]; admin.Name != "admin" || admin.Route != "/admin" || admin.Rejected != 2 || admin.Gauge != "ratelimit_tokens" || admin.Level != 0 {
+		t.Errorf("unexpected admin stats %+v", admin)
+	}
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	registry.ServeHTTP(rr, req)
+	if want := `ratelimit_requests_total{limiter="admin",route="/admin",result="rejected"} 2`; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("expected exposition to contain %q, got:\n%s", want, rr.Body.String())
+	}
+}
+func TestRegistry_SharedMetrics(t *testing.T) {
+	metrics := &Metrics{}
+	a := NewTokenBucket(1, time.Minute, metrics)
+	b := NewTokenBucket(1, time.Minute, metrics)
+	a.Allow()
+	b.Allow()
+	s := snapshot([]Source{{Name: "a", Limiter: a, Metrics: metrics}, {Name: "b", Limiter: b, Metrics: metrics}})
+	if s.Allowed != 2 {
+		t.Errorf("expected shared metrics to be counted once, got %d allowed", s.Allowed)
+	}
+}
@@ -0,0 +1,217 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackendTimeout = 50 * time.Millisecond
+	defaultBreakerTrips   = 5
+	defaultBreakerCool    = 10 * time.Second
+	defaultLocalKeys      = 10000
+)
+
+type FailureMode int
+
+const (
+	FailClosed FailureMode = iota
+	FailOpen
+	FailLocal
+)
+
+func (m FailureMode) String() string {
+	switch m {
+	case FailOpen:
+		return "open"
+	case FailLocal:
+		return "local"
+	}
+	return "closed"
+}
+
+type BackendStats struct {
+	Mode      string `json:"mode"`
+	Calls     int    `json:"calls"`
+	Failures  int    `json:"failures"`
+	Fallbacks int    `json:"fallbacks"`
+	Trips     int    `json:"trips"`
+	Local     int    `json:"local"`
+	Open      bool   `json:"open"`
+}
+type ResilientBackend struct {
+	backend   Backend
+	mode      FailureMode
+	timeout   time.Duration
+	replicas  int
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	maxLocal  int
+	local     map[localKey]*list.Element
+	order     *list.List
+	stats     BackendStats
+}
+type localKey struct {
+	key string
+	cfg Config
+}
+type localEntry struct {
+	localKey
+	limiter Limiter
+}
+
+func NewResilientBackend(backend Backend, mode FailureMode, opts ...Option) *ResilientBackend {
+	o := buildOptions(opts)
+	b := &ResilientBackend{
+		backend:   backend,
+		mode:      mode,
+		timeout:   o.timeout,
+		replicas:  max(o.replicas, 1),
+		threshold: max(o.breakerTrips, 1),
+		cooldown:  o.breakerCool,
+		clock:     o.clock,
+		maxLocal:  defaultLocalKeys,
+		local:     make(map[localKey]*list.Element),
+		order:     list.New(),
+		stats:     BackendStats{Mode: mode.String()},
+	}
+	if o.maxKeys > 0 {
+		b.maxLocal = o.maxKeys
+	}
+	return b
+}
+func (b *ResilientBackend) Take(ctx context.Context, key string, cfg Config, n int) (Decision, error) {
+	if !b.acquire() {
+		return b.fallback(key, cfg, n), nil
+	}
+	call := ctx
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		call, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	d, err := b.backend.Take(call, key, cfg, n)
+	if b.release(ctx, err); err != nil {
+		if ctx.Err() != nil {
+			return Decision{Limit: cfg.Limit, RetryAfter: pollInterval}, ctx.Err()
+		}
+		return b.fallback(key, cfg, n), nil
+	}
+	return d, nil
+}
+func (b *ResilientBackend) Refund(ctx context.Context, key string, cfg Config, n int) error {
+	refunder, ok := b.backend.(Refunder)
+	if !ok || !b.acquire() {
+		return nil
+	}
+	call := ctx
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		call, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	err := refunder.Refund(call, key, cfg, n)
+	b.release(ctx, err)
+	return err
+}
+func (b *ResilientBackend) acquire() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || b.clock.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// release records the outcome of a backend call made on behalf of ctx. An
+// error the caller caused by cancelling ctx says nothing about the backend, so
+// it neither counts as a failure nor closes the breaker.
+func (b *ResilientBackend) release(ctx context.Context, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.stats.Calls++
+	if err != nil && ctx.Err() != nil {
+		b.probing = false
+		return
+	}
+	if err == nil {
+		if !b.openUntil.IsZero() {
+			clear(b.local)
+			b.order.Init()
+		}
+		b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+	b.stats.Failures++
+	b.failures++
+	if b.probing || (b.openUntil.IsZero() && b.failures >= b.threshold) {
+		if !b.probing {
+			b.stats.Trips++
+		}
+		b.openUntil, b.probing = b.clock.Now().Add(b.cooldown), false
+	}
+}
+func (b *ResilientBackend) fallback(key string, cfg Config, n int) Decision {
+	b.mutex.Lock()
+	b.stats.Fallbacks++
+	switch b.mode {
+	case FailOpen:
+		b.mutex.Unlock()
+		return Decision{Allowed: true, Limit: cfg.Limit, Remaining: cfg.Limit}
+	case FailLocal:
+		l := b.localLimiter(key, cfg)
+		b.mutex.Unlock()
+		return l.AllowN(n)
+	}
+	retry := pollInterval
+	if !b.openUntil.IsZero() {
+		retry = max(b.openUntil.Sub(b.clock.Now()), pollInterval)
+	}
+	b.mutex.Unlock()
+	return Decision{Limit: cfg.Limit, RetryAfter: retry}
+}
+func (b *ResilientBackend) localLimiter(key string, cfg Config) Limiter {
+	id := localKey{key: key, cfg: cfg}
+	if el, found := b.local[id]; found {
+		b.order.MoveToFront(el)
+		return el.Value.(*localEntry).limiter
+	}
+	if len(b.local) >= b.maxLocal {
+		oldest := b.order.Remove(b.order.Back()).(*localEntry)
+		delete(b.local, oldest.localKey)
+	}
+	l, _ := New(b.localConfig(cfg), nil, WithClock(b.clock))
+	b.local[id] = b.order.PushFront(&localEntry{localKey: id, limiter: l})
+	return l
+}
+func (b *ResilientBackend) localConfig(cfg Config) Config {
+	share := float64(b.replicas)
+	local := Config{Algorithm: AlgorithmTokenBucket, Limit: max(int(math.Ceil(float64(cfg.Limit)/share)), 1)}
+	switch cfg.Algorithm {
+	case AlgorithmTokenBucket, AlgorithmLeakyBucket, AlgorithmGCRA:
+		local.PerSecond = float64(cfg.TokenRate()) / share
+	default:
+		local.PerSecond = float64(cfg.Limit) / cfg.Window.Seconds() / share
+	}
+	return local
+}
+func (b *ResilientBackend) Stats() BackendStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	stats := b.stats
+	stats.Local = len(b.local)
+	stats.Open = !b.openUntil.IsZero()
+	return stats
+}
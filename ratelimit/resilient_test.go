@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type flakyBackend struct {
+	limiterBackend
+	calls int
+	hang  bool
+}
+
+func (b *flakyBackend) Take(ctx context.Context, key string, cfg Config, n int) (Decision, error) {
+	b.calls++
+	if b.hang {
+		<-ctx.Done()
+		return Decision{}, ctx.Err()
+	}
+	return b.limiterBackend.Take(ctx, key, cfg, n)
+}
+func newFlakyBackend(clock Clock) *flakyBackend {
+	return &flakyBackend{limiterBackend: limiterBackend{limiters: make(map[string]Limiter), clock: clock}}
+}
+func TestResilientBackend_Modes(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 10, Window: time.Second}
+	for _, tt := range []struct {
+		mode    FailureMode
+		allowed int
+	}{
+		{FailClosed, 0},
+		{FailOpen, 20},
+		{FailLocal, 5},
+	} {
+		flaky := newFlakyBackend(clock)
+		flaky.err = errors.New("connection refused")
+		backend := NewResilientBackend(flaky, tt.mode, WithClock(clock), WithReplicas(2), WithCircuitBreaker(100, time.Minute))
+		allowed := 0
+		for i := 0; i < 20; i++ {
+			if d, err := backend.Take(context.Background(), "a", cfg, 1); err != nil {
+				t.Fatal(err)
+			} else if d.Allowed {
+				allowed++
+			}
+		}
+		if allowed != tt.allowed {
+			t.Errorf("expected %s mode to allow %d requests, got %d", tt.mode, tt.allowed, allowed)
+		}
+		if stats := backend.Stats(); stats.Fallbacks != 20 || stats.Failures != 20 || stats.Mode != tt.mode.String() {
+			t.Errorf("unexpected stats for %s mode: %+v", tt.mode, stats)
+		}
+	}
+}
+func TestResilientBackend_LocalKeys(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 2, Window: time.Minute}
+	flaky := newFlakyBackend(clock)
+	flaky.err = errors.New("connection refused")
+	backend := NewResilientBackend(flaky, FailLocal, WithClock(clock), WithMaxKeys(3, EvictOldest))
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		backend.Take(ctx, fmt.Sprint("key-", i), cfg, 1)
+	}
+	if stats := backend.Stats(); stats.Local != 3 {
+		t.Errorf("expected rotating keys to stay within the local limit, got %d", stats.Local)
+	}
+	backend.Take(ctx, "key-97", cfg, 1)
+	backend.Take(ctx, "key-100", cfg, 1)
+	if d, _ := backend.Take(ctx, "key-97", cfg, 1); d.Allowed {
+		t.Errorf("expected a recently used key to keep its local limiter, got %+v", d)
+	}
+	if d, _ := backend.Take(ctx, "key-98", cfg, 1); !d.Allowed {
+		t.Errorf("expected the least recently used key to have been evicted, got %+v", d)
+	}
+	raised := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 4, Window: time.Minute}
+	if d, _ := backend.Take(ctx, "key-97", raised, 1); !d.Allowed || d.Limit != 4 {
+		t.Errorf("expected another config for the same key to get its own local limiter, got %+v", d)
+	}
+	flaky.err = nil
+	clock.Advance(defaultBreakerCool)
+	backend.Take(ctx, "a", cfg, 1)
+	if stats := backend.Stats(); stats.Local != 0 {
+		t.Errorf("expected the local limiters to be dropped once the backend recovers, got %d", stats.Local)
+	}
+}
+func TestResilientBackend_Recovery(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	cfg := Config{Algorithm: AlgorithmFixedWindowCounter, Limit: 2, Window: time.Minute}
+	flaky := newFlakyBackend(clock)
+	flaky.err = errors.New("connection refused")
+	backend := NewResilientBackend(flaky, FailLocal, WithClock(clock), WithCircuitBreaker(3, time.Minute))
+	ctx := context.Background()
+	backend.Take(ctx, "a", cfg, 2)
+	flaky.err = nil
+	backend.Take(ctx, "b", cfg, 1)
+	flaky.err = errors.New("connection refused")
+	if d, _ := backend.Take(ctx, "a", cfg, 1); d.Allowed {
+		t.Errorf("expected a success while the breaker is closed to keep the local limiters, got %+v", d)
+	}
+	flaky.hang, flaky.err = true, nil
+	for i := 0; i < 5; i++ {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := backend.Take(cancelled, "a", cfg, 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the caller's cancellation to be returned, got %v", err)
+		}
+	}
+	if stats := backend.Stats(); stats.Failures != 2 || stats.Open {
+		t.Errorf("expected cancelled calls not to count as failures, got %+v", stats)
+	}
+}
+func TestResilientBackend_CircuitBreaker(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	flaky := newFlakyBackend(clock)
+	flaky.err = errors.New("connection refused")
+	backend := NewResilientBackend(flaky, FailClosed, WithClock(clock), WithCircuitBreaker(3, 10*time.Second))
+	cfg := Config{Algorithm: AlgorithmTokenBucket, Limit: 5, Rate: time.Second}
+	for i := 0; i < 5; i++ {
+		backend.Take(context.Background(), "a", cfg, 1)
+	}
+	if stats := backend.Stats(); flaky.calls != 3 || !stats.Open || stats.Trips != 1 {
+		t.Fatalf("expected the breaker to open after three failures, got %d calls and %+v", flaky.calls, stats)
+	}
+	if d, _ := backend.Take(context.Background(), "a", cfg, 1); d.RetryAfter != 10*time.Second {
+		t.Errorf("expected callers to retry once the breaker half-opens, got %v", d.RetryAfter)
+	}
+	clock.Advance(10 * time.Second)
+	backend.Take(context.Background(), "a", cfg, 1)
+	if stats := backend.Stats(); flaky.calls != 4 || !stats.Open || stats.Trips != 1 {
+		t.Errorf("expected a failed probe to reopen the breaker, got %d calls and %+v", flaky.calls, stats)
+	}
+	clock.Advance(10 * time.Second)
+	flaky.err = nil
+	if d, err := backend.Take(context.Background(), "a", cfg, 1); err != nil || !d.Allowed {
+		t.Errorf("expected a successful probe to reach the backend, got %+v, %v", d, err)
+	}
+	if stats := backend.Stats(); stats.Open || flaky.calls != 5 {
+		t.Errorf("expected the breaker to close, got %+v", stats)
+	}
+}
+func TestResilientBackend_Timeout(t *testing.T) {
+	flaky := newFlakyBackend(RealClock{})
+	flaky.hang = true
+	backend := NewResilientBackend(flaky, FailOpen, WithTimeout(time.Millisecond))
+	l, _ := NewDistributedLimiter(backend, "a", Config{Algorithm: AlgorithmTokenBucket, Limit: 1, Rate: time.Second}, nil)
+	if !l.Allow() {
+		t.Error("expected a hung backend to time out and fail open")
+	}
+	metrics := &Metrics{}
+	rec := httptest.NewRecorder()
+	ExpositionHandler(Source{Name: "shared", Limiter: l, Metrics: metrics, Backend: backend}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	for _, want := range []string{
+		`ratelimit_backend_failures_total{limiter="shared"} 1`,
+		`ratelimit_backend_fallbacks_total{limiter="shared",mode="open"} 1`,
+		`ratelimit_backend_circuit_open{limiter="shared"} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %s in:\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+const DefaultGracePeriod = 10 * time.Second
+
+func ListenAndServe(ctx context.Context, server *http.Server, grace time.Duration) error {
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, server, ln, grace)
+}
+func Serve(ctx context.Context, server *http.Server, ln net.Listener, grace time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(ln)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		server.Close()
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type SlidingWindowCounter struct {
+	limit          int
+	windowDuration time.Duration
+	precision      int
+	interval       time.Duration
+	epoch          time.Time
+	counters       map[int64]int
+	last           int64
+	mutex          sync.Mutex
+	metrics        *Metrics
+	clock          Clock
+}
+
+func NewSlidingWindowCounter(limit int, windowDuration time.Duration, precision int, metrics *Metrics, opts ...Option) *SlidingWindowCounter {
+	o := buildOptions(opts)
+	precision = max(precision, 1)
+	return &SlidingWindowCounter{
+		limit:          limit,
+		windowDuration: windowDuration,
+		precision:      precision,
+		interval:       max(windowDuration/time.Duration(precision), 1),
+		epoch:          o.clock.Now(),
+		counters:       make(map[int64]int),
+		metrics:        metrics,
+		clock:          o.clock,
+	}
+}
+func (s *SlidingWindowCounter) slot(t time.Time) int64 {
+	return int64(t.Sub(s.epoch) / s.interval)
+}
+func (s *SlidingWindowCounter) start(slot int64) time.Time {
+	return s.epoch.Add(time.Duration(slot) * s.interval)
+}
+func (s *SlidingWindowCounter) prune(current int64) {
+	for slot := range s.counters {
+		if slot < current-int64(s.precision) {
+			delete(s.counters, slot)
+		}
+	}
+}
+
+// sum counts every sub-bucket that overlaps the window ending in slot end,
+// including the oldest partial one in full. Requests may therefore be held
+// back for up to one extra interval (window/precision), but no window of the
+// configured length ever admits more than the limit.
+func (s *SlidingWindowCounter) sum(end int64) int {
+	total := 0
+	for slot := end - int64(s.precision); slot <= end; slot++ {
+		total += s.counters[slot]
+	}
+	return total
+}
+func (s *SlidingWindowCounter) used(from int64) int {
+	p := int64(s.precision)
+	total := s.sum(from)
+	used := total
+	for end := from + 1; end <= min(from+p, s.last); end++ {
+		total += s.counters[end] - s.counters[end-p-1]
+		used = max(used, total)
+	}
+	return used
+}
+
+// place finds the first slot at which n more requests keep every window that
+// overlaps it within the limit. It walks each window end once, sliding the
+// sum forward; past the last reserved slot the sums only shrink as the oldest
+// buckets drop out, so the walk ends within one window.
+func (s *SlidingWindowCounter) place(current int64, n int) (int64, bool) {
+	if n < 0 || n > s.limit {
+		return 0, false
+	}
+	p := int64(s.precision)
+	slot, total := current, s.sum(current)
+	for end := current; ; end++ {
+		if end > current {
+			total += s.counters[end] - s.counters[end-p-1]
+		}
+		if end > slot+p || (end > s.last && end > slot) {
+			return slot, true
+		}
+		if total+n > s.limit {
+			slot = end + 1
+		} else if end > s.last {
+			return slot, true
+		}
+	}
+}
+func (s *SlidingWindowCounter) add(slot int64, n int) {
+	s.counters[slot] += n
+	s.last = max(s.last, slot)
+}
+func (s *SlidingWindowCounter) resetAt(now time.Time, current int64) time.Time {
+	for slot := current - int64(s.precision); slot <= current; slot++ {
+		if s.counters[slot] > 0 {
+			return s.start(slot + int64(s.precision) + 1)
+		}
+	}
+	return now
+}
+func (s *SlidingWindowCounter) Allow() bool {
+	return s.AllowN(1).Allowed
+}
+func (s *SlidingWindowCounter) AllowN(n int) Decision {
+	start := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	current := s.slot(now)
+	s.prune(current)
+	d := Decision{Limit: s.limit, Window: s.windowDuration}
+	if slot, ok := s.place(current, n); !ok {
+		d.RetryAfter = InfDuration
+	} else if slot == current {
+		if n > 0 {
+			s.add(current, n)
+		}
+		d.Allowed = true
+	} else {
+		d.RetryAfter = s.start(slot).Sub(now)
+	}
+	d.Remaining = max(s.limit-s.used(current), 0)
+	d.ResetAt = s.resetAt(now, current)
+	s.metrics.record(d.Allowed, start)
+	return d
+}
+func (s *SlidingWindowCounter) Reserve(n int) *Reservation {
+	return s.reserveN(s.clock.Now(), n, InfDuration)
+}
+func (s *SlidingWindowCounter) Wait(ctx context.Context, n int) error {
+	return waitN(ctx, s.clock, s.metrics, s.reserveN, n)
+}
+func (s *SlidingWindowCounter) reserveN(now time.Time, n int, maxWait time.Duration) *Reservation {
+	start := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current := s.slot(now)
+	s.prune(current)
+	r := &Reservation{limit: s.limit, tokens: n, clock: s.clock}
+	if slot, ok := s.place(current, n); ok {
+		at := now
+		if slot > current {
+			at = s.start(slot)
+		}
+		if at.Sub(now) <= maxWait {
+			s.add(slot, n)
+			r.ok = true
+			r.timeToAct = at
+			r.refund = func(now time.Time) { s.cancel(r, now, slot) }
+		}
+	}
+	s.metrics.record(r.ok, start)
+	return r
+}
+func (s *SlidingWindowCounter) cancel(r *Reservation, now time.Time, slot int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.canceled || now.After(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+	if s.counters[slot] -= r.tokens; s.counters[slot] <= 0 {
+		delete(s.counters, slot)
+	}
+}
+func (s *SlidingWindowCounter) level() (string, float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current := s.slot(s.clock.Now())
+	s.prune(current)
+	return "ratelimit_window_fill", float64(min(s.sum(current), s.limit)) / float64(s.limit)
+}
+func (s *SlidingWindowCounter) reconfigure(cfg Config) bool {
+	if cfg.Algorithm != AlgorithmSlidingWindowCounter {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limit = cfg.Limit
+	s.rebucket(cfg.Window, cfg.buckets())
+	return true
+}
+func (s *SlidingWindowCounter) SetWindow(window time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rebucket(window, s.precision)
+}
+func (s *SlidingWindowCounter) SetRate(rate Rate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rebucket(rate.durationFor(float64(s.limit)), s.precision)
+}
+
+// rebucket moves every count into the new bucket holding the last instant of
+// its old bucket, so resizing never lets a request leave the window early.
+// Counts are never moved past the current bucket, and reservations stay at the
+// bucket they were granted for.
+func (s *SlidingWindowCounter) rebucket(window time.Duration, precision int) {
+	precision = max(precision, 1)
+	if window == s.windowDuration && precision == s.precision {
+		return
+	}
+	interval := max(window/time.Duration(precision), 1)
+	current := int64(s.clock.Now().Sub(s.epoch) / interval)
+	counters := make(map[int64]int, len(s.counters))
+	last := int64(0)
+	for slot, count := range s.counters {
+		first := int64(s.start(slot).Sub(s.epoch) / interval)
+		moved := min(int64((s.start(slot+1).Sub(s.epoch)-1)/interval), max(first, current))
+		counters[moved] += count
+		last = max(last, moved)
+	}
+	s.windowDuration, s.precision, s.interval = window, precision, interval
+	s.counters, s.last = counters, last
+	s.prune(current)
+}
+func (s *SlidingWindowCounter) SetCapacity(limit int) error {
+	if limit <= 0 {
+		return ErrInvalidLimit
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limit = limit
+	return nil
+}
+func (s *SlidingWindowCounter) credit(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for slot := s.last; n > 0 && slot >= s.last-int64(s.precision); slot-- {
+		take := min(n, s.counters[slot])
+		if s.counters[slot] -= take; s.counters[slot] <= 0 {
+			delete(s.counters, slot)
+		}
+		n -= take
+	}
+}
+func (s *SlidingWindowCounter) state() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current := s.slot(s.clock.Now())
+	s.prune(current)
+	return State{Limit: s.limit, Window: s.windowDuration, Gauge: "count", Level: float64(s.sum(current))}
+}
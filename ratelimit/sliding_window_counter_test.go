@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowCounter_Allow(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	counter := NewSlidingWindowCounter(2, 2*time.Second, 2, metrics, WithClock(clock))
+	if !counter.Allow() {
+		t.Error("Expected request to be allowed, but it was not")
+	}
+	if !counter.Allow() {
+		t.Error("Expected request to be allowed, but it was not")
+	}
+	if counter.Allow() {
+		t.Error("Expected request to be rejected, but it was allowed")
+	}
+	clock.Advance(2 * time.Second)
+	if counter.Allow() {
+		t.Error("Expected request to be rejected while the oldest sub-bucket overlaps the window")
+	}
+	clock.Advance(time.Second)
+	if !counter.Allow() {
+		t.Error("Expected request to be allowed once the window plus one interval has passed")
+	}
+	if metrics.Allowed != 3 || metrics.Rejected != 2 {
+		t.Errorf("expected 3 allowed and 2 rejected, got %d and %d", metrics.Allowed, metrics.Rejected)
+	}
+	fine := NewSlidingWindowCounter(2, 2*time.Second, 20, nil, WithClock(clock))
+	fine.AllowN(2)
+	clock.Advance(2 * time.Second)
+	if fine.Allow() {
+		t.Error("Expected request to be rejected while the oldest sub-bucket overlaps the window")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !fine.Allow() {
+		t.Error("Expected a higher precision to shrink the over-rejection to one 100ms interval")
+	}
+}
+func TestSlidingWindowCounter_AllowN(t *testing.T) {
+	counter := NewSlidingWindowCounter(5, time.Minute, 6, nil)
+	if d := counter.AllowN(3); !d.Allowed || d.Remaining != 2 {
+		t.Fatalf("unexpected decision for AllowN(3): %+v", d)
+	}
+	if counter.AllowN(3).Allowed {
+		t.Error("expected AllowN(3) to exceed the limit")
+	}
+}
+func TestSlidingWindowCounter_Slides(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	counter := NewSlidingWindowCounter(10, time.Minute, 6, nil, WithClock(clock))
+	counter.AllowN(4)
+	clock.Advance(25 * time.Second)
+	counter.AllowN(6)
+	d := counter.AllowN(4)
+	if d.Allowed {
+		t.Fatal("expected the window to be full")
+	}
+	if d.RetryAfter != 45*time.Second {
+		t.Errorf("RetryAfter = %v, want 45s until the first sub-bucket leaves the window", d.RetryAfter)
+	}
+	if !d.ResetAt.Equal(start.Add(70 * time.Second)) {
+		t.Errorf("ResetAt = %v, want %v", d.ResetAt, start.Add(70*time.Second))
+	}
+	clock.Advance(45 * time.Second)
+	if d := counter.AllowN(4); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected the first 4 to have slid out of the window, got %+v", d)
+	}
+}
+func TestSlidingWindowCounter_NeverExceedsLimit(t *testing.T) {
+	const limit = 20
+	window := 10 * time.Second
+	for _, precision := range []int{1, 4, 10} {
+		start := time.Now()
+		clock := NewManualClock(start)
+		counter := NewSlidingWindowCounter(limit, window, precision, nil, WithClock(clock))
+		rng := rand.New(rand.NewSource(int64(precision)))
+		type event struct {
+			at time.Time
+			n  int
+		}
+		var allowed []event
+		for i := 0; i < 5000; i++ {
+			clock.Advance(time.Duration(rng.Intn(300)) * time.Millisecond)
+			n := 1 + rng.Intn(3)
+			if counter.AllowN(n).Allowed {
+				allowed = append(allowed, event{clock.Now(), n})
+			}
+		}
+		for i, e := range allowed {
+			total := 0
+			for _, prev := range allowed[:i+1] {
+				if e.at.Sub(prev.at) < window {
+					total += prev.n
+				}
+			}
+			if total > limit {
+				t.Fatalf("precision %d: %d admitted in the window ending at %v, limit %d", precision, total, e.at.Sub(start), limit)
+			}
+		}
+		if len(allowed) == 0 {
+			t.Fatalf("precision %d: expected some requests to be admitted", precision)
+		}
+	}
+}
+func TestSlidingWindowCounter_Reserve(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	counter := NewSlidingWindowCounter(4, 4*time.Second, 4, nil, WithClock(clock))
+	counter.AllowN(4)
+	r := counter.Reserve(2)
+	if !r.OK() || r.Delay() != 5*time.Second {
+		t.Fatalf("expected a reservation once the first sub-bucket leaves, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	if next := counter.Reserve(3); next.Delay() != 10*time.Second {
+		t.Errorf("expected the next reservation to queue behind the first, got %v", next.Delay())
+	} else {
+		next.Cancel()
+	}
+	r.Cancel()
+	clock.Advance(5 * time.Second)
+	if d := counter.AllowN(4); !d.Allowed {
+		t.Errorf("expected cancelled reservations to free their sub-bucket, got %+v", d)
+	}
+}
+func TestSlidingWindowCounter_SetWindow(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	counter := NewSlidingWindowCounter(4, 4*time.Second, 4, nil, WithClock(clock))
+	counter.AllowN(3)
+	clock.Advance(1500 * time.Millisecond)
+	counter.AllowN(1)
+	counter.SetWindow(8 * time.Second)
+	if d := counter.AllowN(1); d.Allowed || d.RetryAfter != 8500*time.Millisecond {
+		t.Errorf("expected the counts to be kept in 2s buckets, got %+v", d)
+	}
+	clock.Advance(8500 * time.Millisecond)
+	if d := counter.AllowN(3); !d.Allowed || d.Remaining != 1 {
+		t.Errorf("expected the first bucket to leave the longer window, got %+v", d)
+	}
+	counter.SetWindow(2 * time.Second)
+	if d := counter.AllowN(2); d.Allowed || d.RetryAfter != 2500*time.Millisecond {
+		t.Errorf("expected a shorter window to keep counting recent requests, got %+v", d)
+	}
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type SlidingWindowLog struct {
+	limit          int
+	windowDuration time.Duration
+	requests       *list.List
+	count          int
+	mutex          sync.Mutex
+	metrics        *Metrics
+	clock          Clock
+}
+
+type logEntry struct {
+	at     time.Time
+	weight int
+}
+
+func NewSlidingWindowLog(limit int, windowDuration time.Duration, metrics *Metrics, opts ...Option) *SlidingWindowLog {
+	o := buildOptions(opts)
+	return &SlidingWindowLog{
+		limit:          limit,
+		windowDuration: windowDuration,
+		requests:       list.New(),
+		metrics:        metrics,
+		clock:          o.clock,
+	}
+}
+func (s *SlidingWindowLog) Allow() bool {
+	return s.AllowN(1).Allowed
+}
+func (s *SlidingWindowLog) AllowN(n int) Decision {
+	start := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	s.evict(now)
+	d := Decision{Limit: s.limit, Window: s.windowDuration}
+	if n >= 0 && s.count+n <= s.limit {
+		if n > 0 {
+			s.requests.PushBack(logEntry{at: now, weight: n})
+			s.count += n
+		}
+		d.Allowed = true
+	} else if n < 0 || n > s.limit {
+		d.RetryAfter = InfDuration
+	} else {
+		d.RetryAfter = s.expiry(s.count + n - s.limit).Sub(now)
+	}
+	d.Remaining = max(s.limit-s.count, 0)
+	d.ResetAt = now
+	if s.requests.Len() > 0 {
+		d.ResetAt = s.expiry(1)
+	}
+	s.metrics.record(d.Allowed, start)
+	return d
+}
+func (s *SlidingWindowLog) evict(now time.Time) {
+	windowStart := now.Add(-s.windowDuration)
+	for s.requests.Len() > 0 {
+		oldest := s.requests.Front()
+		if entry := oldest.Value.(logEntry); !entry.at.After(windowStart) {
+			s.count -= entry.weight
+			s.requests.Remove(oldest)
+		} else {
+			break
+		}
+	}
+}
+func (s *SlidingWindowLog) expiry(weight int) time.Time {
+	e := s.requests.Front()
+	freed := e.Value.(logEntry).weight
+	for freed < weight {
+		e = e.Next()
+		freed += e.Value.(logEntry).weight
+	}
+	return e.Value.(logEntry).at.Add(s.windowDuration)
+}
+func (s *SlidingWindowLog) Reserve(n int) *Reservation {
+	return s.reserveN(s.clock.Now(), n, InfDuration)
+}
+func (s *SlidingWindowLog) Wait(ctx context.Context, n int) error {
+	return waitN(ctx, s.clock, s.metrics, s.reserveN, n)
+}
+func (s *SlidingWindowLog) reserveN(now time.Time, n int, maxWait time.Duration) *Reservation {
+	start := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.evict(now)
+	r := &Reservation{limit: s.limit, tokens: n, clock: s.clock}
+	if n < 0 || n > s.limit {
+		s.metrics.record(false, start)
+		return r
+	}
+	r.timeToAct = now
+	if s.count+n > s.limit {
+		r.timeToAct = s.expiry(s.count + n - s.limit)
+	}
+	if r.timeToAct.Sub(now) <= maxWait {
+		r.ok = true
+		if n > 0 {
+			entry := s.requests.PushBack(logEntry{at: r.timeToAct, weight: n})
+			s.count += n
+			r.refund = func(now time.Time) { s.cancel(r, entry, now) }
+		}
+	}
+	s.metrics.record(r.ok, start)
+	return r
+}
+func (s *SlidingWindowLog) cancel(r *Reservation, entry *list.Element, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.canceled || now.After(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+	for e := s.requests.Back(); e != nil; e = e.Prev() {
+		if e == entry {
+			s.count -= e.Value.(logEntry).weight
+			s.requests.Remove(e)
+			return
+		}
+	}
+}
+func (s *SlidingWindowLog) level() (string, float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	windowStart := s.clock.Now().Add(-s.windowDuration)
+	count := 0
+	for e := s.requests.Back(); e != nil && e.Value.(logEntry).at.After(windowStart); e = e.Prev() {
+		count += e.Value.(logEntry).weight
+	}
+	return "ratelimit_window_fill", float64(count) / float64(s.limit)
+}
+func (s *SlidingWindowLog) reconfigure(cfg Config) bool {
+	if cfg.Algorithm != AlgorithmSlidingWindowLog {
+		return false
+	}
+	s.SetCapacity(cfg.Limit)
+	s.SetWindow(cfg.Window)
+	return true
+}
+func (s *SlidingWindowLog) SetCapacity(limit int) error {
+	if limit <= 0 {
+		return ErrInvalidLimit
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limit = limit
+	return nil
+}
+func (s *SlidingWindowLog) SetWindow(window time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.windowDuration = window
+}
+func (s *SlidingWindowLog) credit(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for n > 0 && s.requests.Len() > 0 {
+		newest := s.requests.Back()
+		entry := newest.Value.(logEntry)
+		take := min(n, entry.weight)
+		if entry.weight -= take; entry.weight == 0 {
+			s.requests.Remove(newest)
+		} else {
+			newest.Value = entry
+		}
+		s.count -= take
+		n -= take
+	}
+}
+func (s *SlidingWindowLog) state() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	windowStart := s.clock.Now().Add(-s.windowDuration)
+	count := 0
+	for e := s.requests.Back(); e != nil && e.Value.(logEntry).at.After(windowStart); e = e.Prev() {
+		count += e.Value.(logEntry).weight
+	}
+	return State{Limit: s.limit, Window: s.windowDuration, Gauge: "count", Level: float64(count), Entries: s.requests.Len()}
+}
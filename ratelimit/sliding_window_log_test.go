@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLog_Allow(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	sl := NewSlidingWindowLog(3, time.Minute, metrics, WithClock(clock))
+	for i := 0; i < 3; i++ {
+		if !sl.Allow() {
+			t.Errorf("Expected request %d to be allowed", i+1)
+		}
+	}
+	if sl.Allow() {
+		t.Error("Expected 4th request to be rejected")
+	}
+	if metrics.Rejected != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", metrics.Rejected)
+	}
+}
+func TestSlidingWindowLog_Expiry(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	sl := NewSlidingWindowLog(1, 100*time.Millisecond, nil, WithClock(clock))
+	if !sl.Allow() {
+		t.Fatal("expected to allow the first request")
+	}
+	if sl.Allow() {
+		t.Fatal("expected to reject the second request")
+	}
+	clock.Advance(150 * time.Millisecond)
+	if !sl.Allow() {
+		t.Fatal("expected to allow a request after the entry left the window")
+	}
+}
+func TestSlidingWindowLog_Decision(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	sl := NewSlidingWindowLog(3, time.Minute, nil, WithClock(clock))
+	sl.Allow()
+	clock.Advance(10 * time.Second)
+	sl.Allow()
+	clock.Advance(10 * time.Second)
+	sl.Allow()
+	d := sl.AllowN(2)
+	if d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected a full log to reject, got %+v", d)
+	}
+	if d.RetryAfter != 50*time.Second {
+		t.Errorf("RetryAfter = %v, want 50s", d.RetryAfter)
+	}
+	if !d.ResetAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("ResetAt = %v, want %v", d.ResetAt, start.Add(time.Minute))
+	}
+	clock.Advance(40 * time.Second)
+	if d := sl.AllowN(1); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected the oldest entry to have expired, got %+v", d)
+	}
+}
+func TestSlidingWindowLog_AllowN(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	sl := NewSlidingWindowLog(10, time.Minute, nil, WithClock(clock))
+	if d := sl.AllowN(6); !d.Allowed || d.Remaining != 4 {
+		t.Fatalf("unexpected decision for AllowN(6): %+v", d)
+	}
+	clock.Advance(20 * time.Second)
+	if d := sl.AllowN(3); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("unexpected decision for AllowN(3): %+v", d)
+	}
+	if sl.requests.Len() != 2 {
+		t.Errorf("expected one log entry per call, got %d", sl.requests.Len())
+	}
+	d := sl.AllowN(5)
+	if d.Allowed {
+		t.Fatal("expected AllowN(5) to be rejected")
+	}
+	if d.RetryAfter != 40*time.Second {
+		t.Errorf("RetryAfter = %v, want 40s", d.RetryAfter)
+	}
+	d = sl.AllowN(8)
+	if d.RetryAfter != 60*time.Second {
+		t.Errorf("RetryAfter = %v, want 60s", d.RetryAfter)
+	}
+	clock.Advance(40 * time.Second)
+	if d := sl.AllowN(7); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected the first weighted entry to have expired, got %+v", d)
+	}
+}
+func TestSlidingWindowLog_Reserve(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	sl := NewSlidingWindowLog(3, time.Minute, nil, WithClock(clock))
+	sl.AllowN(2)
+	r := sl.Reserve(2)
+	if !r.OK() || r.Delay() != time.Minute {
+		t.Fatalf("expected the reservation to wait for the first entry, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	if d := sl.AllowN(1); d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected the reserved tokens to count against the log, got %+v", d)
+	}
+	r.Cancel()
+	if d := sl.AllowN(1); !d.Allowed || d.Remaining != 0 || sl.requests.Len() != 2 {
+		t.Errorf("expected cancelling to remove the weighted entry, got %+v with %d entries", d, sl.requests.Len())
+	}
+	r.Cancel()
+	if sl.count != 3 {
+		t.Errorf("expected a second cancel to be a no-op, got count %d", sl.count)
+	}
+	if r := sl.Reserve(4); r.OK() {
+		t.Error("expected a reservation above the limit to fail")
+	}
+	if err := sl.SetCapacity(1); err != nil {
+		t.Fatal(err)
+	}
+	if d := sl.AllowN(1); d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected Remaining to be clamped at zero after shrinking, got %+v", d)
+	}
+}
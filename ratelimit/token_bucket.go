@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type TokenBucket struct {
+	capacity   int
+	tokens     float64
+	rate       Rate
+	lastRefill time.Time
+	mutex      sync.Mutex
+	metrics    *Metrics
+	clock      Clock
+}
+
+func NewTokenBucket(capacity int, rate time.Duration, metrics *Metrics, opts ...Option) *TokenBucket {
+	return NewTokenBucketRate(capacity, Every(rate), metrics, opts...)
+}
+func NewTokenBucketRate(capacity int, rate Rate, metrics *Metrics, opts ...Option) *TokenBucket {
+	o := buildOptions(opts)
+	return &TokenBucket{
+		capacity:   capacity,
+		tokens:     float64(capacity),
+		rate:       rate,
+		lastRefill: o.clock.Now(),
+		metrics:    metrics,
+		clock:      o.clock,
+	}
+}
+func (b *TokenBucket) refill(now time.Time) {
+	if now.After(b.lastRefill) {
+		b.tokens = min(b.tokens+b.rate.tokensFor(now.Sub(b.lastRefill)), float64(b.capacity))
+		b.lastRefill = now
+	}
+}
+func (b *TokenBucket) delay(n int) time.Duration {
+	if n < 0 || n > b.capacity {
+		return InfDuration
+	}
+	if float64(n) <= b.tokens+tokenEpsilon {
+		return 0
+	}
+	return b.rate.durationFor(float64(n) - b.tokens)
+}
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(1).Allowed
+}
+func (b *TokenBucket) AllowN(n int) Decision {
+	start := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.clock.Now()
+	b.refill(now)
+	d := Decision{Limit: b.capacity, Window: b.rate.durationFor(float64(b.capacity))}
+	if d.RetryAfter = b.delay(n); d.RetryAfter == 0 {
+		b.tokens -= float64(n)
+		d.Allowed = true
+	}
+	d.Remaining = max(int(b.tokens+tokenEpsilon), 0)
+	d.ResetAt = b.fullAt(now)
+	b.metrics.record(d.Allowed, start)
+	return d
+}
+func (b *TokenBucket) fullAt(now time.Time) time.Time {
+	return now.Add(b.rate.durationFor(float64(b.capacity) - b.tokens))
+}
+func (b *TokenBucket) Reserve(n int) *Reservation {
+	return b.reserveN(b.clock.Now(), n, InfDuration)
+}
+func (b *TokenBucket) Wait(ctx context.Context, n int) error {
+	return waitN(ctx, b.clock, b.metrics, b.reserveN, n)
+}
+func (b *TokenBucket) reserveN(now time.Time, n int, maxWait time.Duration) *Reservation {
+	start := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	r := &Reservation{limit: b.capacity, tokens: n, clock: b.clock}
+	if wait := b.delay(n); wait <= maxWait && wait != InfDuration {
+		b.tokens -= float64(n)
+		r.ok = true
+		r.timeToAct = now.Add(wait)
+		r.refund = func(now time.Time) { b.cancel(r, now) }
+	}
+	b.metrics.record(r.ok, start)
+	return r
+}
+func (b *TokenBucket) cancel(r *Reservation, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if r.canceled || now.After(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+	b.refill(now)
+	b.tokens = min(b.tokens+float64(r.tokens), float64(b.capacity))
+}
+func (b *TokenBucket) level() (string, float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(b.clock.Now())
+	return "ratelimit_tokens", b.tokens
+}
+func (b *TokenBucket) reconfigure(cfg Config) bool {
+	if cfg.Algorithm != AlgorithmTokenBucket {
+		return false
+	}
+	b.SetCapacity(cfg.Limit)
+	b.SetRate(cfg.TokenRate())
+	return true
+}
+func (b *TokenBucket) SetRate(rate Rate) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(b.clock.Now())
+	b.rate = rate
+}
+func (b *TokenBucket) SetCapacity(capacity int) error {
+	if capacity <= 0 {
+		return ErrInvalidLimit
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(b.clock.Now())
+	b.tokens = b.tokens * float64(capacity) / float64(b.capacity)
+	b.capacity = capacity
+	return nil
+}
+func (b *TokenBucket) credit(n int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(b.clock.Now())
+	b.tokens = min(b.tokens+float64(n), float64(b.capacity))
+}
+func (b *TokenBucket) state() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(b.clock.Now())
+	return State{Limit: b.capacity, Rate: b.rate, Gauge: "tokens", Level: b.tokens}
+}
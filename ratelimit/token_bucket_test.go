@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	metrics := &Metrics{}
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(3, time.Second, metrics, WithClock(clock))
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow() {
+			t.Errorf("Expected request %d to be allowed", i+1)
+		}
+	}
+	if bucket.Allow() {
+		t.Error("Expected 4th request to be rejected")
+	}
+	if metrics.Rejected != 1 {
+		t.Errorf("Expected 1 rejected request, got %d", metrics.Rejected)
+	}
+	clock.Advance(2 * time.Second)
+	if !bucket.Allow() {
+		t.Error("Expected request to be allowed after refill")
+	}
+}
+func TestTokenBucket_AllowN(t *testing.T) {
+	bucket := NewTokenBucket(5, time.Second, nil)
+	d := bucket.AllowN(3)
+	if !d.Allowed || d.Limit != 5 || d.Remaining != 2 {
+		t.Errorf("unexpected decision for AllowN(3): %+v", d)
+	}
+	if d := bucket.AllowN(3); d.Allowed || d.Remaining != 2 {
+		t.Errorf("expected AllowN(3) to be rejected without consuming tokens, got %+v", d)
+	}
+}
+func TestTokenBucket_Decision(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	bucket := NewTokenBucket(2, time.Second, nil, WithClock(clock))
+	bucket.AllowN(2)
+	clock.Advance(400 * time.Millisecond)
+	d := bucket.AllowN(1)
+	if d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected an empty bucket to reject, got %+v", d)
+	}
+	if d.RetryAfter != 600*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 600ms", d.RetryAfter)
+	}
+	if !d.ResetAt.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("ResetAt = %v, want %v", d.ResetAt, start.Add(2*time.Second))
+	}
+	if d := bucket.AllowN(3); d.RetryAfter != InfDuration {
+		t.Errorf("expected a request larger than capacity to never be retryable, got %v", d.RetryAfter)
+	}
+}
+func TestTokenBucket_SubTickRefill(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucket(5, 100*time.Millisecond, nil, WithClock(clock))
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if bucket.Allow() {
+			allowed++
+		}
+		clock.Advance(30 * time.Millisecond)
+	}
+	if allowed != 34 {
+		t.Errorf("expected a burst of 5 plus 29 refilled tokens polling every 30ms, got %d", allowed)
+	}
+}
+func TestTokenBucketRate(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	bucket := NewTokenBucketRate(3, 2.5, nil, WithClock(clock))
+	bucket.AllowN(3)
+	if d := bucket.AllowN(1); d.RetryAfter != 400*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 400ms at 2.5 tokens per second", d.RetryAfter)
+	}
+	clock.Advance(time.Second)
+	if d := bucket.AllowN(2); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected 2.5 tokens after one second, got %+v", d)
+	}
+}
@@ -1,3 +0,0 @@
-module sliding-window-counter
-
-go 1.21.5
@@ -1,3 +0,0 @@
-module sliding-window-log
-
-go 1.21.5
@@ -1,3 +0,0 @@
-module token-bucket
-
-go 1.21.5
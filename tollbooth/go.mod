@@ -1,8 +0,0 @@
-module tollbooth
-
-go 1.21.5
-
-require (
-	github.com/didip/tollbooth/v7 v7.0.2 // indirect
-	github.com/go-pkgz/expirable-cache/v3 v3.0.0 // indirect
-)
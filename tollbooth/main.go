@@ -1,38 +0,0 @@
-package main
-import (
-	"encoding/json"
-	"log"
-	"net/http"
-	tollbooth "github.com/didip/tollbooth/v7"
-)
-type Message struct {
-	Status string `json:"status"`
-	Body   string `json:"body"`
-}
-func endpointHandler(writer http.ResponseWriter, request *http.Request) {
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
-	message := Message{
-		Status: "Successful",
-		Body:   "Hi! You've reached the API. How may I help you?",
-	}
-	err := json.NewEncoder(writer).Encode(&message)
-	if err != nil {
-		return
-	}
-}
-func main() {
-	message := Message{
-		Status: "Request Failed",
-		Body:   "The API is at capacity, try again later.",
-	}
-	jsonMessage, _ := json.Marshal(message)
-	tlbthLimiter := tollbooth.NewLimiter(1, nil)
-	tlbthLimiter.SetMessageContentType("application/json")
-	tlbthLimiter.SetMessage(string(jsonMessage))
-	http.Handle("/ping", tollbooth.LimitFuncHandler(tlbthLimiter, endpointHandler))
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
-		log.Println("There was an error listening on port :8080", err)
-	}
-}
\ No newline at end of file